@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"net/http"
+	"time"
+)
+
+func runConsole(args []string) error {
+
+	var lf loginFlags
+	fs := flag.NewFlagSet("console", flag.ExitOnError)
+	lf.register(fs)
+	destination := fs.String("destination", "", "console page to open, defaults to console home page")
+	issuer := fs.String("issuer", "", "url users are sent to when console session expires")
+	sessionDuration := fs.Duration("session-duration", 0, "console session duration, 15m to 12h")
+	fs.Parse(args)
+
+	creds, err := lf.credentials()
+	if err != nil {
+		return err
+	}
+
+	if *issuer == "" {
+		*issuer = lf.profile.AdfsHost
+	}
+	consoleURL, err := creds.ConsoleURL(aws.ConsoleOptions{
+		Destination:     *destination,
+		Issuer:          *issuer,
+		SessionDuration: *sessionDuration,
+		HTTPClient:      &http.Client{Timeout: time.Duration(lf.profile.Timeout)},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(consoleURL)
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/saml"
+	"io/ioutil"
+	"os"
+)
+
+func runDecode(args []string) error {
+
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	format := fs.String("format", saml.DecodeFormatText, "output format: text, json or xml")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: decode [flags] [file]\n\ndecodes saml response (base64, url encoded or xml) from file or stdin\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return errors.New("decode reads one file, or stdin")
+	}
+
+	// no login, assertion is only decoded locally
+	var input []byte
+	var err error
+	if fs.NArg() == 0 || fs.Arg(0) == "-" {
+		input, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		input, err = ioutil.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		return fmt.Errorf("read saml response: %v", err)
+	}
+
+	assertion, err := saml.Decode(string(input))
+	if err != nil {
+		return err
+	}
+	return assertion.Render(os.Stdout, *format)
+}
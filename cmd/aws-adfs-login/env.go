@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+func runEnv(args []string) error {
+
+	var lf loginFlags
+	fs := flag.NewFlagSet("env", flag.ExitOnError)
+	lf.register(fs)
+	format := fs.String("format", aws.EnvFormatPOSIX, "output format: posix (bash, zsh, sh), fish, powershell or dotenv")
+	fs.Parse(args)
+
+	creds, err := lf.credentials()
+	if err != nil {
+		return err
+	}
+
+	out, err := creds.FormatEnv(*format)
+	if err != nil {
+		return err
+	}
+	fmt.Print(out)
+	return nil
+}
+
+func runExec(args []string) error {
+
+	var lf loginFlags
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	lf.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: exec [flags] -- command [args]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return errors.New("command to run is required")
+	}
+
+	creds, err := lf.credentials()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	cmd.Env = append(credentialsFreeEnviron(), creds.Environ()...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// removes variables that would take precedence over or conflict with injected credentials
+func credentialsFreeEnviron() []string {
+
+	conflicting := []string{"AWS_ACCESS_KEY_ID=", "AWS_SECRET_ACCESS_KEY=", "AWS_SESSION_TOKEN=",
+		"AWS_SECURITY_TOKEN=", "AWS_CREDENTIAL_EXPIRATION=", "AWS_PROFILE=", "AWS_DEFAULT_PROFILE="}
+
+	var environ []string
+	for _, v := range os.Environ() {
+		keep := true
+		for _, prefix := range conflicting {
+			if strings.HasPrefix(v, prefix) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			environ = append(environ, v)
+		}
+	}
+	return environ
+}
@@ -0,0 +1,55 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/imds"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func runIMDS(args []string) error {
+
+	var lf loginFlags
+	fs := flag.NewFlagSet("imds", flag.ExitOnError)
+	lf.register(fs)
+	addr := fs.String("addr", "127.0.0.1:9912", "listen address")
+	allowV1 := fs.Bool("allow-v1", false, "serve requests without session token (IMDSv1)")
+	fs.Parse(args)
+
+	_, role, authenticator, err := lf.login()
+	if err != nil {
+		return err
+	}
+
+	server := imds.NewServer(role, authenticator, lf.loginOptions())
+	server.AllowV1 = *allowV1
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "serving %s credentials, use:\n", role.Arn)
+	fmt.Printf("export AWS_EC2_METADATA_SERVICE_ENDPOINT=http://%s\n", listener.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return server.Serve(ctx, listener)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"os"
+)
+
+func runList(args []string) error {
+
+	var lf loginFlags
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	lf.register(fs)
+	format := fs.String("format", aws.ListFormatTable, "output format: table, json, yaml or csv")
+	accounts := fs.Bool("accounts", false, "list accounts instead of roles")
+	query := fs.String("query", "", "list only roles matching 'account/role' query, names can be glob patterns or /regexp/")
+	fs.Parse(args)
+
+	// role selected by -role flag or profile is not needed, all roles available to the user are listed
+	authenticator, err := lf.authenticator()
+	if err != nil {
+		return err
+	}
+	roles, err := authenticator.LoadAWSRoles()
+	if err != nil {
+		return fmt.Errorf("login: %v", err)
+	}
+
+	if *query != "" {
+		roles, err = roles.Find(aws.ParseRoleQuery(*query))
+		if err != nil {
+			return err
+		}
+	}
+
+	if *accounts {
+		return roles.Accounts().Render(os.Stdout, *format)
+	}
+	return roles.Render(os.Stdout, *format)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command line client for aws adfs login
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"console": {"print aws console sign-in url", runConsole},
+	"decode":  {"decode saml assertion offline, from file or stdin", runDecode},
+	"env":     {"print credentials as shell environment variables", runEnv},
+	"exec":    {"run command with credentials in its environment", runExec},
+	"imds":    {"serve credentials as ec2 instance metadata service (IMDSv2)", runIMDS},
+	"list":    {"list accounts and roles available to the user", runList},
+	"login":   {"write credentials to aws shared credentials file profile", runLogin},
+	"serve":   {"serve credentials in ecs container credentials endpoint format", runServe},
+}
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		// exec command exits with the status of the child process
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].description)
+	}
+}
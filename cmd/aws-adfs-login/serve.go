@@ -0,0 +1,64 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/ecs"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func runServe(args []string) error {
+
+	var lf loginFlags
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	lf.register(fs)
+	addr := fs.String("addr", "127.0.0.1:9911", "listen address")
+	token := fs.String("token", "", "authorization token, generated if empty")
+	fs.Parse(args)
+
+	roles, role, authenticator, err := lf.login()
+	if err != nil {
+		return err
+	}
+
+	if *token == "" {
+		if *token, err = ecs.NewToken(); err != nil {
+			return err
+		}
+	}
+
+	server, err := ecs.NewServer(roles, role.Arn, authenticator, lf.loginOptions(), *token)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "serving %s credentials, use:\n", role.Arn)
+	fmt.Printf("export AWS_CONTAINER_CREDENTIALS_FULL_URI=http://%s%s\n", listener.Addr(), ecs.CredentialsPath)
+	fmt.Printf("export AWS_CONTAINER_AUTHORIZATION_TOKEN=%s\n", *token)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return server.Serve(ctx, listener)
+}
@@ -16,12 +16,10 @@ package aws
 import (
 	"context"
 	"fmt"
-	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
 	"sort"
 	"time"
-
-	"github.com/aws/aws-sdk-go-v2/aws/external"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 type Roles []Role
@@ -53,10 +51,11 @@ func (roles Roles) RoleByRoleArn(roleArn string) (Role, error) {
 	return Role{}, fmt.Errorf("role with %s arn does not exist", roleArn)
 }
 
-func (roles Roles) Accounts() []Account {
+// Returns accounts of the roles sorted by name
+func (roles Roles) Accounts() Accounts {
 
 	accountsSet := make(map[string]interface{})
-	var accounts []Account
+	var accounts Accounts
 	for _, role := range roles {
 		if _, ok := accountsSet[role.Account.Id]; !ok {
 			accountsSet[role.Account.Id] = nil
@@ -70,6 +69,8 @@ func (roles Roles) Accounts() []Account {
 	return accounts
 }
 
+type Accounts []Account
+
 type Account struct {
 	Id   string
 	Name string
@@ -92,25 +93,77 @@ func (role Role) Login() (Credentials, error) {
 }
 
 func (role Role) LoginWithDuration(duration time.Duration) (Credentials, error) {
-	cfg, err := external.LoadDefaultAWSConfig()
-	if err != nil {
-		return Credentials{}, fmt.Errorf("load default aws config: %v", err)
+	return role.LoginWithOptions(LoginOptions{Duration: duration})
+}
+
+// Options for role login, zero value logs in for 60 minutes using anonymous sts client that ignores
+// shared aws config files, AWS_PROFILE and credentials set in the environment
+type LoginOptions struct {
+	Duration time.Duration
+	// sts region, defaults to region of the role arn partition (us-east-1, us-gov-west-1 or cn-north-1), ignored
+	// when STSClient is set
+	Region string
+	// use proxy (HTTP_PROXY, HTTPS_PROXY, NO_PROXY) and custom CA bundle (AWS_CA_BUNDLE) set in the environment,
+	// shared aws config is still not loaded. Ignored when STSClient is set
+	InheritNetworkSettings bool
+	// client used to assume the role, defaults to aws sdk client returned by NewSTSClient
+	STSClient STSClient
+	// inline session policy (json document) and managed policy arns used to down-scope role permissions
+	Policy     string
+	PolicyArns []string
+	// receives debug events of sts calls, *slog.Logger can be used
+	Logger debuglog.Logger
+	// retries throttled and failed sts calls, in addition to retries of aws sdk client, zero value does not retry
+	Retry retry.Policy
+}
+
+func (role Role) LoginWithOptions(options LoginOptions) (Credentials, error) {
+
+	if err := validateSessionPolicy(options.Policy, options.PolicyArns); err != nil {
+		return Credentials{}, fmt.Errorf("aws assume role %s with saml: %v", role.Arn, err)
+	}
+
+	stsClient := options.STSClient
+	if stsClient == nil {
+		region := options.Region
+		if region == "" {
+			region = roleSTSRegion(role.Arn)
+		}
+		c, err := NewSTSClient(region, options.InheritNetworkSettings)
+		if err != nil {
+			return Credentials{}, err
+		}
+		stsClient = c
 	}
 
-	durationSeconds := int64(duration / time.Second)
+	duration := options.Duration
+	if duration == 0 {
+		duration = 60 * time.Minute
+	}
 
-	input := &sts.AssumeRoleWithSAMLInput{
-		PrincipalArn:    aws.String(role.PrincipalArn),
-		RoleArn:         aws.String(role.Arn),
-		SAMLAssertion:   aws.String(role.SamlAssertion),
-		DurationSeconds: aws.Int64(durationSeconds),
+	input := AssumeRoleWithSAMLInput{
+		PrincipalArn:  role.PrincipalArn,
+		RoleArn:       role.Arn,
+		SAMLAssertion: role.SamlAssertion,
+		Duration:      duration,
+		Policy:        options.Policy,
+		PolicyArns:    options.PolicyArns,
 	}
 
-	out, err := sts.New(cfg).AssumeRoleWithSAMLRequest(input).Send(context.Background())
+	logger := debuglog.OrNop(options.Logger)
+	logger.Debug("sts assume role with saml", "role", role.Arn, "duration", duration, "session_policy", options.Policy != "" || len(options.PolicyArns) != 0)
+	var creds Credentials
+	err := options.Retry.Do(context.Background(), logger, "sts assume role with saml", func() error {
+		var err error
+		creds, err = stsClient.AssumeRoleWithSAML(context.Background(), input)
+		return err
+	})
 	if err != nil {
+		logger.Debug("sts assume role with saml failed", "role", role.Arn, "error", err)
 		return Credentials{}, fmt.Errorf("aws assume role %s with saml: %v", role.Arn, err)
 	}
-	return fromSTSCredentials(out.Credentials), nil
+	logger.Debug("sts credentials received", "role", role.Arn, "assumed_role", creds.AssumedRoleUserArn, "expiration", creds.Expiration)
+	return creds, nil
 }
 
 func (role Role) String() string {
@@ -122,18 +175,14 @@ type Credentials struct {
 	Expiration      time.Time
 	SecretAccessKey string
 	SessionToken    string
-}
-
-func fromSTSCredentials(stsCreds *sts.Credentials) Credentials {
 
-	if stsCreds == nil {
-		return Credentials{}
-	}
-
-	return Credentials{
-		AccessKeyId:     aws.StringValue(stsCreds.AccessKeyId),
-		Expiration:      aws.TimeValue(stsCreds.Expiration),
-		SecretAccessKey: aws.StringValue(stsCreds.SecretAccessKey),
-		SessionToken:    aws.StringValue(stsCreds.SessionToken),
-	}
+	// assumed role session arn e.g. 'arn:aws:sts::123456789:assumed-role/Admin/user@test.com'
+	AssumedRoleUserArn string
+	// source identity set by saml assertion 'https://aws.amazon.com/SAML/Attributes/SourceIdentity' attribute
+	SourceIdentity string
+	// issuer and subject (NameID) of the saml assertion
+	Issuer  string
+	Subject string
+	// percentage of the allowed session policy size used by the session
+	PackedPolicySize int
 }
@@ -0,0 +1,134 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws_test
+
+import (
+	"errors"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws/awstest"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var testRole = aws.Role{
+	Account:       aws.Account{Id: "123456789", Name: "test"},
+	Arn:           "arn:aws:iam::123456789:role/ADFS-User",
+	Name:          "ADFS-User",
+	PrincipalArn:  "arn:aws:iam::123456789:saml-provider/ADFS",
+	SamlAssertion: "c2FtbA==",
+}
+
+func TestLoginWithOptions(t *testing.T) {
+
+	stsClient := awstest.NewFakeSTSClient()
+	creds, err := testRole.LoginWithOptions(aws.LoginOptions{Duration: 15 * time.Minute, STSClient: stsClient})
+	require.NoError(t, err)
+	assert.NotEmpty(t, creds.AccessKeyId)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), creds.Expiration, time.Minute)
+
+	inputs := stsClient.Inputs()
+	require.Equal(t, 1, len(inputs))
+	assert.Equal(t, aws.AssumeRoleWithSAMLInput{
+		PrincipalArn:  testRole.PrincipalArn,
+		RoleArn:       testRole.Arn,
+		SAMLAssertion: testRole.SamlAssertion,
+		Duration:      15 * time.Minute,
+	}, inputs[0])
+}
+
+func TestLoginWithOptionsDefaultDuration(t *testing.T) {
+
+	stsClient := awstest.NewFakeSTSClient()
+	_, err := testRole.LoginWithOptions(aws.LoginOptions{STSClient: stsClient})
+	require.NoError(t, err)
+	assert.Equal(t, 60*time.Minute, stsClient.Inputs()[0].Duration)
+}
+
+func TestLoginWithOptionsConfiguredCredentials(t *testing.T) {
+
+	expected := aws.Credentials{AccessKeyId: "ASIA123", SecretAccessKey: "secret", SessionToken: "token"}
+	stsClient := awstest.NewFakeSTSClient()
+	stsClient.Credentials[testRole.Arn] = expected
+
+	creds, err := testRole.LoginWithOptions(aws.LoginOptions{STSClient: stsClient})
+	require.NoError(t, err)
+	assert.Equal(t, expected, creds)
+}
+
+func TestLoginWithOptionsError(t *testing.T) {
+
+	stsClient := awstest.NewFakeSTSClient()
+	stsClient.Err = errors.New("access denied")
+
+	_, err := testRole.LoginWithOptions(aws.LoginOptions{STSClient: stsClient})
+	assert.EqualError(t, err, "aws assume role arn:aws:iam::123456789:role/ADFS-User with saml: access denied")
+}
+
+type testAPIError string
+
+func (e testAPIError) Error() string     { return "api error " + string(e) }
+func (e testAPIError) ErrorCode() string { return string(e) }
+
+func TestLoginWithOptionsRetry(t *testing.T) {
+
+	stsClient := awstest.NewFakeSTSClient()
+	stsClient.Errs = []error{testAPIError("Throttling"), testAPIError("IDPCommunicationError")}
+	retry := retry.Policy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	_, err := testRole.LoginWithOptions(aws.LoginOptions{STSClient: stsClient, Retry: retry})
+	require.NoError(t, err)
+	assert.Len(t, stsClient.Inputs(), 3)
+
+	stsClient = awstest.NewFakeSTSClient()
+	stsClient.Errs = []error{testAPIError("Throttling"), testAPIError("AccessDenied")}
+	_, err = testRole.LoginWithOptions(aws.LoginOptions{STSClient: stsClient, Retry: retry})
+	assert.EqualError(t, err, "aws assume role arn:aws:iam::123456789:role/ADFS-User with saml: api error AccessDenied")
+	assert.Len(t, stsClient.Inputs(), 2, "error that is not retryable is returned")
+
+	stsClient = awstest.NewFakeSTSClient()
+	stsClient.Err = testAPIError("Throttling")
+	_, err = testRole.LoginWithOptions(aws.LoginOptions{STSClient: stsClient, Retry: retry})
+	assert.Error(t, err)
+	assert.Len(t, stsClient.Inputs(), 3, "attempts are limited")
+
+	stsClient = awstest.NewFakeSTSClient()
+	stsClient.Errs = []error{testAPIError("Throttling")}
+	_, err = testRole.LoginWithOptions(aws.LoginOptions{STSClient: stsClient})
+	assert.Error(t, err, "zero value policy does not retry")
+}
+
+func TestLoginWithOptionsSessionPolicy(t *testing.T) {
+
+	policy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:Get*","Resource":"*"}]}`
+	policyArns := []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}
+
+	stsClient := awstest.NewFakeSTSClient()
+	_, err := testRole.LoginWithOptions(aws.LoginOptions{STSClient: stsClient, Policy: policy, PolicyArns: policyArns})
+	require.NoError(t, err)
+
+	input := stsClient.Inputs()[0]
+	assert.Equal(t, policy, input.Policy)
+	assert.Equal(t, policyArns, input.PolicyArns)
+}
+
+func TestLoginWithOptionsInvalidSessionPolicy(t *testing.T) {
+
+	stsClient := awstest.NewFakeSTSClient()
+	_, err := testRole.LoginWithOptions(aws.LoginOptions{STSClient: stsClient, Policy: "{"})
+	require.Error(t, err)
+	assert.Empty(t, stsClient.Inputs(), "sts must not be called with invalid policy")
+}
@@ -0,0 +1,36 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package awstest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// Returns base64 encoded saml response (as in aws.Role SamlAssertion) that is accepted until notOnOrAfter
+func SamlAssertion(notOnOrAfter time.Time) string {
+
+	assertion := fmt.Sprintf(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">
+  <Assertion xmlns="urn:oasis:names:tc:SAML:2.0:assertion">
+    <Subject>
+      <SubjectConfirmation>
+        <SubjectConfirmationData NotOnOrAfter="%s" />
+      </SubjectConfirmation>
+    </Subject>
+    <Conditions NotOnOrAfter="%s" />
+  </Assertion>
+</samlp:Response>`, notOnOrAfter.UTC().Format(time.RFC3339), notOnOrAfter.Add(time.Hour).UTC().Format(time.RFC3339))
+	return base64.StdEncoding.EncodeToString([]byte(assertion))
+}
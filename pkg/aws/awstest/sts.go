@@ -0,0 +1,74 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package awstest provides in-memory fakes for testing login flows offline.
+package awstest
+
+import (
+	"context"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"sync"
+	"time"
+)
+
+// In-memory aws.STSClient, records every request. Returns Errs to the first requests in order, Err if set,
+// credentials configured for the requested role arn, or generated credentials that expire after requested duration
+type FakeSTSClient struct {
+	Errs        []error
+	Err         error
+	Credentials map[string]aws.Credentials // role arn -> credentials
+
+	mu     sync.Mutex
+	inputs []aws.AssumeRoleWithSAMLInput
+}
+
+func NewFakeSTSClient() *FakeSTSClient {
+	return &FakeSTSClient{Credentials: make(map[string]aws.Credentials)}
+}
+
+func (c *FakeSTSClient) AssumeRoleWithSAML(ctx context.Context, input aws.AssumeRoleWithSAMLInput) (aws.Credentials, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inputs = append(c.inputs, input)
+	if err := ctx.Err(); err != nil {
+		return aws.Credentials{}, err
+	}
+	if len(c.inputs) <= len(c.Errs) {
+		return aws.Credentials{}, c.Errs[len(c.inputs)-1]
+	}
+	if c.Err != nil {
+		return aws.Credentials{}, c.Err
+	}
+	if creds, ok := c.Credentials[input.RoleArn]; ok {
+		return creds, nil
+	}
+
+	n := len(c.inputs)
+	return aws.Credentials{
+		AccessKeyId:     fmt.Sprintf("ASIAFAKE%012d", n),
+		Expiration:      time.Now().Add(input.Duration),
+		SecretAccessKey: fmt.Sprintf("fake-secret-%d", n),
+		SessionToken:    fmt.Sprintf("fake-session-token-%d", n),
+	}, nil
+}
+
+// Returns all requests received so far, in order
+func (c *FakeSTSClient) Inputs() []aws.AssumeRoleWithSAMLInput {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]aws.AssumeRoleWithSAMLInput(nil), c.inputs...)
+}
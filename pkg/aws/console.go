@@ -0,0 +1,146 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// federation endpoint and console of aws partitions, selected by partition of the assumed role arn
+var consoleEndpoints = map[string]struct {
+	federation string
+	console    string
+}{
+	"aws":        {"https://signin.aws.amazon.com/federation", "https://console.aws.amazon.com/"},
+	"aws-us-gov": {"https://signin.amazonaws-us-gov.com/federation", "https://console.amazonaws-us-gov.com/"},
+	"aws-cn":     {"https://signin.amazonaws.cn/federation", "https://console.amazonaws.cn/"},
+}
+
+type ConsoleOptions struct {
+	// console page to open after sign in, defaults to console home page
+	Destination string
+	// url users are sent to when console session expires, optional
+	Issuer string
+	// console session duration, 15 minutes to 12 hours, defaults to federation endpoint default (1 hour)
+	SessionDuration time.Duration
+	// federation endpoint url, defaults to endpoint of the partition of assumed role
+	FederationEndpoint string
+	// defaults to http client with 20 seconds timeout
+	HTTPClient *http.Client
+}
+
+// Returns AWS console sign-in url for credentials, sign-in token is requested from the federation endpoint
+func (c Credentials) ConsoleURL(options ConsoleOptions) (string, error) {
+
+	endpoints := consoleEndpoints[arnPartition(c.AssumedRoleUserArn)]
+	if options.FederationEndpoint == "" {
+		options.FederationEndpoint = endpoints.federation
+	}
+	if options.Destination == "" {
+		options.Destination = endpoints.console
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 20 * time.Second}
+	}
+
+	token, err := c.signinToken(options)
+	if err != nil {
+		return "", fmt.Errorf("console url: %v", err)
+	}
+
+	u, err := url.Parse(options.FederationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("console url: parse federation endpoint %s: %v", options.FederationEndpoint, err)
+	}
+	params := url.Values{}
+	params.Set("Action", "login")
+	params.Set("Destination", options.Destination)
+	params.Set("SigninToken", token)
+	if options.Issuer != "" {
+		params.Set("Issuer", options.Issuer)
+	}
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
+
+func (c Credentials) signinToken(options ConsoleOptions) (string, error) {
+
+	session, err := json.Marshal(map[string]string{
+		"sessionId":    c.AccessKeyId,
+		"sessionKey":   c.SecretAccessKey,
+		"sessionToken": c.SessionToken,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(options.FederationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("parse federation endpoint %s: %v", options.FederationEndpoint, err)
+	}
+
+	params := url.Values{}
+	params.Set("Action", "getSigninToken")
+	if options.SessionDuration != 0 {
+		params.Set("SessionDuration", strconv.Itoa(int(options.SessionDuration/time.Second)))
+	}
+	params.Set("Session", string(session))
+
+	// session is sent in the body, so credentials do not end up in access logs
+	resp, err := options.HTTPClient.Post(u.String(), "application/x-www-form-urlencoded", strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("get signin token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("get signin token: %v", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("get signin token: status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response struct {
+		SigninToken string
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("get signin token: %v", err)
+	}
+	if response.SigninToken == "" {
+		return "", errors.New("get signin token: response does not contain signin token")
+	}
+	return response.SigninToken, nil
+}
+
+// returns partition of arn e.g. 'aws-us-gov' for 'arn:aws-us-gov:sts::123456789:assumed-role/Admin/user',
+// defaults to 'aws'
+func arnPartition(arn string) string {
+
+	arnFields := strings.Split(arn, ":")
+	if len(arnFields) > 1 {
+		if _, ok := consoleEndpoints[arnFields[1]]; ok {
+			return arnFields[1]
+		}
+	}
+	return "aws"
+}
@@ -0,0 +1,83 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestConsoleURL(t *testing.T) {
+
+	var form url.Values
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"SigninToken":"test-signin-token"}`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	consoleURL, err := testCredentials.ConsoleURL(ConsoleOptions{
+		Destination:        "https://console.aws.amazon.com/s3/",
+		Issuer:             "https://sso.test.com",
+		SessionDuration:    2 * time.Hour,
+		FederationEndpoint: server.URL + "/federation",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "getSigninToken", form.Get("Action"))
+	assert.Equal(t, "7200", form.Get("SessionDuration"))
+	var session map[string]string
+	require.NoError(t, json.Unmarshal([]byte(form.Get("Session")), &session))
+	assert.Equal(t, map[string]string{
+		"sessionId":    testCredentials.AccessKeyId,
+		"sessionKey":   testCredentials.SecretAccessKey,
+		"sessionToken": testCredentials.SessionToken,
+	}, session)
+
+	u, err := url.Parse(consoleURL)
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/federation", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "login", u.Query().Get("Action"))
+	assert.Equal(t, "https://console.aws.amazon.com/s3/", u.Query().Get("Destination"))
+	assert.Equal(t, "https://sso.test.com", u.Query().Get("Issuer"))
+	assert.Equal(t, "test-signin-token", u.Query().Get("SigninToken"))
+}
+
+func TestConsoleURLFederationError(t *testing.T) {
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid session", http.StatusBadRequest)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	_, err := testCredentials.ConsoleURL(ConsoleOptions{FederationEndpoint: server.URL})
+	assert.EqualError(t, err, "console url: get signin token: status code 400: invalid session")
+}
+
+func TestArnPartition(t *testing.T) {
+
+	assert.Equal(t, "aws", arnPartition(""))
+	assert.Equal(t, "aws", arnPartition("arn:aws:sts::123456789:assumed-role/Admin/user"))
+	assert.Equal(t, "aws-us-gov", arnPartition("arn:aws-us-gov:sts::123456789:assumed-role/Admin/user"))
+	assert.Equal(t, "aws-cn", arnPartition("arn:aws-cn:sts::123456789:assumed-role/Admin/user"))
+}
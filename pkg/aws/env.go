@@ -0,0 +1,102 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Formats supported by Credentials.FormatEnv
+const (
+	EnvFormatPOSIX      = "posix" // bash, zsh, sh
+	EnvFormatFish       = "fish"
+	EnvFormatPowerShell = "powershell"
+	EnvFormatDotenv     = "dotenv"
+)
+
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// Returns credentials as aws sdk environment variables
+func (c Credentials) EnvVars() []EnvVar {
+
+	vars := []EnvVar{
+		{"AWS_ACCESS_KEY_ID", c.AccessKeyId},
+		{"AWS_SECRET_ACCESS_KEY", c.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", c.SessionToken},
+	}
+	if !c.Expiration.IsZero() {
+		vars = append(vars, EnvVar{"AWS_CREDENTIAL_EXPIRATION", c.Expiration.UTC().Format(time.RFC3339)})
+	}
+	return vars
+}
+
+// Returns credentials in 'NAME=value' format used by os/exec
+func (c Credentials) Environ() []string {
+
+	var environ []string
+	for _, v := range c.EnvVars() {
+		environ = append(environ, v.Name+"="+v.Value)
+	}
+	return environ
+}
+
+// Returns shell statements that set credentials environment variables, one per line.
+// Format is one of EnvFormat constants, bash, zsh and sh are accepted as posix
+func (c Credentials) FormatEnv(format string) (string, error) {
+
+	var line func(EnvVar) string
+	switch strings.ToLower(format) {
+	case EnvFormatPOSIX, "bash", "zsh", "sh":
+		line = func(v EnvVar) string { return fmt.Sprintf("export %s=%s", v.Name, quotePOSIX(v.Value)) }
+	case EnvFormatFish:
+		line = func(v EnvVar) string { return fmt.Sprintf("set -gx %s %s;", v.Name, quoteFish(v.Value)) }
+	case EnvFormatPowerShell, "pwsh":
+		line = func(v EnvVar) string { return fmt.Sprintf("$env:%s = %s", v.Name, quotePowerShell(v.Value)) }
+	case EnvFormatDotenv:
+		line = func(v EnvVar) string { return fmt.Sprintf("%s=%s", v.Name, quoteDotenv(v.Value)) }
+	default:
+		return "", fmt.Errorf("unknown environment format %q", format)
+	}
+
+	var b strings.Builder
+	for _, v := range c.EnvVars() {
+		b.WriteString(line(v))
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// single quotes do not expand anything in posix shells, single quote itself is closed, escaped and reopened
+func quotePOSIX(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// fish single quotes only expand \' and \\
+func quoteFish(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// powershell single quoted strings are literal, single quote is escaped by doubling it
+func quotePowerShell(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func quoteDotenv(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`).Replace(s) + `"`
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var testCredentials = Credentials{
+	AccessKeyId:     "ASIAEXAMPLE",
+	SecretAccessKey: "se'cr\\et",
+	SessionToken:    "to+ken/==",
+	Expiration:      time.Date(2019, 11, 1, 20, 26, 47, 0, time.UTC),
+}
+
+func TestFormatEnv(t *testing.T) {
+
+	tests := map[string]string{
+		"bash": `export AWS_ACCESS_KEY_ID='ASIAEXAMPLE'
+export AWS_SECRET_ACCESS_KEY='se'\''cr\et'
+export AWS_SESSION_TOKEN='to+ken/=='
+export AWS_CREDENTIAL_EXPIRATION='2019-11-01T20:26:47Z'
+`,
+		"fish": `set -gx AWS_ACCESS_KEY_ID 'ASIAEXAMPLE';
+set -gx AWS_SECRET_ACCESS_KEY 'se\'cr\\et';
+set -gx AWS_SESSION_TOKEN 'to+ken/==';
+set -gx AWS_CREDENTIAL_EXPIRATION '2019-11-01T20:26:47Z';
+`,
+		"powershell": `$env:AWS_ACCESS_KEY_ID = 'ASIAEXAMPLE'
+$env:AWS_SECRET_ACCESS_KEY = 'se''cr\et'
+$env:AWS_SESSION_TOKEN = 'to+ken/=='
+$env:AWS_CREDENTIAL_EXPIRATION = '2019-11-01T20:26:47Z'
+`,
+		"dotenv": `AWS_ACCESS_KEY_ID="ASIAEXAMPLE"
+AWS_SECRET_ACCESS_KEY="se'cr\\et"
+AWS_SESSION_TOKEN="to+ken/=="
+AWS_CREDENTIAL_EXPIRATION="2019-11-01T20:26:47Z"
+`,
+	}
+
+	for format, expected := range tests {
+		actual, err := testCredentials.FormatEnv(format)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual, format)
+	}
+}
+
+func TestFormatEnvUnknownFormat(t *testing.T) {
+
+	_, err := testCredentials.FormatEnv("cmd")
+	assert.EqualError(t, err, `unknown environment format "cmd"`)
+}
+
+func TestEnviron(t *testing.T) {
+
+	creds := testCredentials
+	creds.Expiration = time.Time{}
+	assert.Equal(t, []string{
+		"AWS_ACCESS_KEY_ID=ASIAEXAMPLE",
+		`AWS_SECRET_ACCESS_KEY=se'cr\et`,
+		"AWS_SESSION_TOKEN=to+ken/==",
+	}, creds.Environ())
+}
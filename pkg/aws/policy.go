@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// sts limits for session policies passed to AssumeRoleWithSAML
+const (
+	maxSessionPolicySize = 2048
+	maxSessionPolicyArns = 10
+)
+
+// validates session policies locally, so invalid policy does not cost sts round trip and the error is readable
+func validateSessionPolicy(policy string, policyArns []string) error {
+
+	if policy != "" {
+		if len(policy) > maxSessionPolicySize {
+			return fmt.Errorf("session policy has %d characters, maximum is %d", len(policy), maxSessionPolicySize)
+		}
+		var document map[string]interface{}
+		if err := json.Unmarshal([]byte(policy), &document); err != nil {
+			return fmt.Errorf("session policy is not valid json document: %v", err)
+		}
+	}
+
+	if len(policyArns) > maxSessionPolicyArns {
+		return fmt.Errorf("%d session policy arns, maximum is %d", len(policyArns), maxSessionPolicyArns)
+	}
+	for _, arn := range policyArns {
+		// arn:partition:iam::account:policy/name, account is empty for aws managed policies
+		arnFields := strings.SplitN(arn, ":", 6)
+		if len(arnFields) != 6 || arnFields[0] != "arn" || arnFields[2] != "iam" ||
+			!strings.HasPrefix(arnFields[5], "policy/") {
+			return fmt.Errorf("session policy arn %q is not valid iam policy arn", arn)
+		}
+	}
+	return nil
+}
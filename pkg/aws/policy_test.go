@@ -0,0 +1,59 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestValidateSessionPolicy(t *testing.T) {
+
+	readOnly := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:Get*","Resource":"*"}]}`
+	assert.NoError(t, validateSessionPolicy("", nil))
+	assert.NoError(t, validateSessionPolicy(readOnly, []string{
+		"arn:aws:iam::aws:policy/ReadOnlyAccess",
+		"arn:aws-us-gov:iam::123456789:policy/team/Custom",
+	}))
+}
+
+func TestValidateSessionPolicyErrors(t *testing.T) {
+
+	var tooManyArns []string
+	for i := 0; i < 11; i++ {
+		tooManyArns = append(tooManyArns, fmt.Sprintf("arn:aws:iam::123456789:policy/p%d", i))
+	}
+
+	tests := []struct {
+		policy     string
+		policyArns []string
+		err        string
+	}{
+		{`{"Version":`, nil, "session policy is not valid json document"},
+		{`["not", "object"]`, nil, "session policy is not valid json document"},
+		{`{"Sid":"` + strings.Repeat("x", 2048) + `"}`, nil, "session policy has 2058 characters, maximum is 2048"},
+		{"", tooManyArns, "11 session policy arns, maximum is 10"},
+		{"", []string{"ReadOnlyAccess"}, `session policy arn "ReadOnlyAccess" is not valid iam policy arn`},
+		{"", []string{"arn:aws:iam::123456789:role/Admin"}, `session policy arn "arn:aws:iam::123456789:role/Admin" is not valid iam policy arn`},
+	}
+
+	for _, test := range tests {
+		err := validateSessionPolicy(test.policy, test.policyArns)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), test.err)
+		}
+	}
+}
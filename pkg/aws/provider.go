@@ -0,0 +1,237 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"sync"
+	"time"
+)
+
+// credentials are refreshed this long before they expire, unless configured otherwise
+const defaultRefreshWindow = 5 * time.Minute
+
+var _ aws.CredentialsProvider = (*CredentialsProvider)(nil)
+
+// Full login used by CredentialsProvider when saml assertion of the role expires, it must not require user
+// interaction e.g. no MFA or MFA factor that does not need input. See client.Authenticator
+type Authenticator interface {
+	LoadAWSRoles() (Roles, error)
+}
+
+// Implements aws sdk credentials provider, credentials are refreshed ahead of expiration by assuming the role
+// with cached saml assertion while it is valid, otherwise with assertion from a new login by authenticator.
+// Concurrent callers share one refresh. Credentials that failed to refresh are returned until they expire, the
+// refresh is retried by later calls
+type CredentialsProvider struct {
+	authenticator Authenticator
+	options       LoginOptions
+	// credentials are refreshed when they expire in less than refresh window, set it before the provider is used,
+	// it must not change afterwards
+	RefreshWindow time.Duration
+
+	mu         sync.Mutex
+	role       Role
+	creds      Credentials
+	refresh    *refreshCall
+	refreshErr error
+}
+
+type refreshCall struct {
+	done  chan struct{}
+	creds Credentials
+	err   error
+}
+
+func NewCredentialsProvider(role Role, authenticator Authenticator, options LoginOptions) *CredentialsProvider {
+	return &CredentialsProvider{
+		authenticator: authenticator,
+		options:       options,
+		RefreshWindow: defaultRefreshWindow,
+		role:          role,
+	}
+}
+
+func (p *CredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+
+	creds, err := p.Credentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     creds.AccessKeyId,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Source:          "ADFS",
+		CanExpire:       true,
+		Expires:         creds.Expiration,
+		AccountID:       p.Role().Account.Id,
+	}, nil
+}
+
+// Returns cached credentials or refreshes them if they are about to expire
+func (p *CredentialsProvider) Credentials(ctx context.Context) (Credentials, error) {
+
+	p.mu.Lock()
+	if p.isValid(p.creds) {
+		creds := p.creds
+		p.mu.Unlock()
+		return creds, nil
+	}
+
+	call := p.refresh
+	if call == nil {
+		call = &refreshCall{done: make(chan struct{})}
+		p.refresh = call
+		go p.doRefresh(call)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.creds, call.err
+	case <-ctx.Done():
+		return Credentials{}, ctx.Err()
+	}
+}
+
+// Returns role that is currently used, saml assertion is updated after each full login
+func (p *CredentialsProvider) Role() Role {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.role
+}
+
+// Returns error of the last refresh, nil if it succeeded. Credentials returns cached credentials despite the error
+// until they expire
+func (p *CredentialsProvider) RefreshError() error {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.refreshErr
+}
+
+func (p *CredentialsProvider) doRefresh(call *refreshCall) {
+
+	p.mu.Lock()
+	role := p.role
+	p.mu.Unlock()
+
+	creds, role, err := p.login(role)
+
+	p.mu.Lock()
+	p.refreshErr = err
+	if err == nil {
+		p.role = role
+		p.creds = creds
+	} else if p.creds.AccessKeyId != "" && time.Now().Before(p.creds.Expiration) {
+		debuglog.OrNop(p.options.Logger).Debug("refresh failed, cached credentials are used until they expire",
+			"role", role.Arn, "expiration", p.creds.Expiration, "error", err)
+		creds, err = p.creds, nil
+	}
+	p.refresh = nil
+	p.mu.Unlock()
+
+	call.creds, call.err = creds, err
+	close(call.done)
+}
+
+func (p *CredentialsProvider) login(role Role) (Credentials, Role, error) {
+
+	logger := debuglog.OrNop(p.options.Logger)
+	if expiration, err := samlAssertionExpiration(role.SamlAssertion); err == nil && time.Now().Before(expiration) {
+		logger.Debug("refresh credentials with cached saml assertion", "role", role.Arn, "assertion_expiration", expiration)
+		creds, err := role.LoginWithOptions(p.options)
+		if err != nil {
+			return Credentials{}, role, fmt.Errorf("refresh credentials: %v", err)
+		}
+		return creds, role, nil
+	}
+
+	if p.authenticator == nil {
+		return Credentials{}, role, errors.New("refresh credentials: saml assertion expired and no authenticator set")
+	}
+
+	logger.Debug("refresh credentials with new login, saml assertion expired", "role", role.Arn)
+	roles, err := p.authenticator.LoadAWSRoles()
+	if err != nil {
+		// wrapped so that callers can tell rejected mfa from other failures
+		return Credentials{}, role, fmt.Errorf("refresh credentials: %w", err)
+	}
+	newRole, err := roles.RoleByRoleArn(role.Arn)
+	if err != nil {
+		return Credentials{}, role, fmt.Errorf("refresh credentials: %v", err)
+	}
+
+	creds, err := newRole.LoginWithOptions(p.options)
+	if err != nil {
+		return Credentials{}, role, fmt.Errorf("refresh credentials: %v", err)
+	}
+	return creds, newRole, nil
+}
+
+func (p *CredentialsProvider) isValid(creds Credentials) bool {
+	return creds.AccessKeyId != "" && time.Now().Add(p.RefreshWindow).Before(creds.Expiration)
+}
+
+type samlExpiration struct {
+	Conditions struct {
+		NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+	} `xml:"Assertion>Conditions"`
+	SubjectConfirmationData struct {
+		NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+	} `xml:"Assertion>Subject>SubjectConfirmation>SubjectConfirmationData"`
+}
+
+// returns time when sts stops accepting saml assertion, the earlier of subject confirmation and conditions expiration
+func samlAssertionExpiration(samlAssertion string) (time.Time, error) {
+
+	decoded, err := base64.StdEncoding.DecodeString(samlAssertion)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decode saml assertion: %v", err)
+	}
+
+	var response samlExpiration
+	if err := xml.NewDecoder(bytes.NewReader(decoded)).Decode(&response); err != nil {
+		return time.Time{}, fmt.Errorf("parse saml assertion: %v", err)
+	}
+
+	var expiration time.Time
+	for _, v := range []string{response.Conditions.NotOnOrAfter, response.SubjectConfirmationData.NotOnOrAfter} {
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse saml assertion expiration %s: %v", v, err)
+		}
+		if expiration.IsZero() || t.Before(expiration) {
+			expiration = t
+		}
+	}
+
+	if expiration.IsZero() {
+		return time.Time{}, errors.New("saml assertion does not contain expiration")
+	}
+	return expiration, nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws_test
+
+import (
+	"context"
+	"errors"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws/awstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testAuthenticator struct {
+	calls int32
+	role  aws.Role
+}
+
+func (a *testAuthenticator) LoadAWSRoles() (aws.Roles, error) {
+	atomic.AddInt32(&a.calls, 1)
+	return aws.Roles{a.role}, nil
+}
+
+func TestCredentialsProviderCachesCredentials(t *testing.T) {
+
+	role := testRole
+	role.SamlAssertion = awstest.SamlAssertion(time.Now().Add(5 * time.Minute))
+	stsClient := awstest.NewFakeSTSClient()
+	authenticator := &testAuthenticator{}
+
+	provider := aws.NewCredentialsProvider(role, authenticator, aws.LoginOptions{STSClient: stsClient})
+	creds1, err := provider.Retrieve(context.Background())
+	require.NoError(t, err)
+	creds2, err := provider.Retrieve(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, creds1, creds2)
+	assert.True(t, creds1.CanExpire)
+	assert.Equal(t, "123456789", creds1.AccountID)
+	assert.Equal(t, 1, len(stsClient.Inputs()))
+	assert.Equal(t, int32(0), authenticator.calls, "valid saml assertion is reused")
+}
+
+func TestCredentialsProviderReauthenticatesWithExpiredAssertion(t *testing.T) {
+
+	role := testRole
+	role.SamlAssertion = awstest.SamlAssertion(time.Now().Add(-time.Minute))
+	freshRole := testRole
+	freshRole.SamlAssertion = awstest.SamlAssertion(time.Now().Add(5 * time.Minute))
+
+	stsClient := awstest.NewFakeSTSClient()
+	authenticator := &testAuthenticator{role: freshRole}
+
+	provider := aws.NewCredentialsProvider(role, authenticator, aws.LoginOptions{STSClient: stsClient})
+	_, err := provider.Retrieve(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(1), authenticator.calls)
+	assert.Equal(t, freshRole.SamlAssertion, stsClient.Inputs()[0].SAMLAssertion)
+	assert.Equal(t, freshRole.SamlAssertion, provider.Role().SamlAssertion)
+}
+
+func TestCredentialsProviderRefreshesAheadOfExpiration(t *testing.T) {
+
+	role := testRole
+	role.SamlAssertion = awstest.SamlAssertion(time.Now().Add(5 * time.Minute))
+	stsClient := awstest.NewFakeSTSClient()
+
+	// credentials expire within refresh window, every call refreshes them
+	provider := aws.NewCredentialsProvider(role, nil, aws.LoginOptions{STSClient: stsClient, Duration: time.Minute})
+	for i := 0; i < 3; i++ {
+		_, err := provider.Retrieve(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, len(stsClient.Inputs()))
+}
+
+func TestCredentialsProviderSingleFlight(t *testing.T) {
+
+	role := testRole
+	role.SamlAssertion = awstest.SamlAssertion(time.Now().Add(-time.Minute))
+	freshRole := testRole
+	freshRole.SamlAssertion = awstest.SamlAssertion(time.Now().Add(5 * time.Minute))
+
+	stsClient := awstest.NewFakeSTSClient()
+	authenticator := &testAuthenticator{role: freshRole}
+	provider := aws.NewCredentialsProvider(role, authenticator, aws.LoginOptions{STSClient: stsClient})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := provider.Retrieve(context.Background())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), authenticator.calls)
+	assert.Equal(t, 1, len(stsClient.Inputs()))
+}
+
+func TestCredentialsProviderWithoutAuthenticator(t *testing.T) {
+
+	role := testRole
+	role.SamlAssertion = awstest.SamlAssertion(time.Now().Add(-time.Minute))
+
+	provider := aws.NewCredentialsProvider(role, nil, aws.LoginOptions{STSClient: awstest.NewFakeSTSClient()})
+	_, err := provider.Retrieve(context.Background())
+	assert.EqualError(t, err, "refresh credentials: saml assertion expired and no authenticator set")
+}
+
+func TestCredentialsProviderRefreshError(t *testing.T) {
+
+	role := testRole
+	role.SamlAssertion = awstest.SamlAssertion(time.Now().Add(5 * time.Minute))
+	stsClient := awstest.NewFakeSTSClient()
+	// within refresh window, every call refreshes
+	stsClient.Credentials[role.Arn] = aws.Credentials{AccessKeyId: "ASIA123", Expiration: time.Now().Add(time.Minute)}
+
+	provider := aws.NewCredentialsProvider(role, nil, aws.LoginOptions{STSClient: stsClient})
+	creds, err := provider.Credentials(context.Background())
+	require.NoError(t, err)
+
+	stsClient.Err = errors.New("sts unavailable")
+	cached, err := provider.Credentials(context.Background())
+	require.NoError(t, err, "credentials that did not expire yet are returned")
+	assert.Equal(t, creds, cached)
+	assert.EqualError(t, provider.RefreshError(), "refresh credentials: aws assume role arn:aws:iam::123456789:role/ADFS-User with saml: sts unavailable")
+	cached, err = provider.Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, creds, cached)
+	assert.Equal(t, 3, len(stsClient.Inputs()), "failed refresh is retried")
+
+	stsClient = awstest.NewFakeSTSClient()
+	stsClient.Credentials[role.Arn] = aws.Credentials{AccessKeyId: "ASIA123", Expiration: time.Now().Add(-time.Minute)}
+	provider = aws.NewCredentialsProvider(role, nil, aws.LoginOptions{STSClient: stsClient})
+	_, err = provider.Credentials(context.Background())
+	require.NoError(t, err)
+
+	stsClient.Err = errors.New("sts unavailable")
+	_, err = provider.Credentials(context.Background())
+	assert.EqualError(t, err, "refresh credentials: aws assume role arn:aws:iam::123456789:role/ADFS-User with saml: sts unavailable",
+		"expired credentials are not returned")
+}
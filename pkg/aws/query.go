@@ -0,0 +1,284 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maximum number of suggestions returned for query that does not match any role
+const maxSuggestions = 5
+
+// Selects roles by account and role. Each field is account name or id / role name (case insensitive),
+// glob pattern e.g. 'prod-*' or regular expression in slashes e.g. '/^prod-(eu|us)$/'. Empty field matches all.
+// Role can also be full role arn
+type RoleQuery struct {
+	Account string
+	Role    string
+}
+
+// Parses 'account/role' e.g. 'prod-payments/Admin', 'role' or role arn. Account regular expression is followed by
+// role directly or after a slash, '/^prod-/Admin' and '/^prod-//Admin' are the same, slashes inside regular expressions
+// are escaped e.g. '/^prod\/eu/'
+func ParseRoleQuery(query string) RoleQuery {
+
+	query = strings.TrimSpace(query)
+	if strings.HasPrefix(query, "arn:") {
+		return RoleQuery{Role: query}
+	}
+	if strings.HasPrefix(query, "/") {
+		end := closingSlash(query)
+		if end < 0 || end == len(query)-1 {
+			// role regular expression alone
+			return RoleQuery{Role: query}
+		}
+		role := query[end+1:]
+		// optional separator, unless the rest is role regular expression
+		if strings.HasPrefix(role, "//") || (strings.HasPrefix(role, "/") && !isRegexp(role)) {
+			role = role[1:]
+		}
+		return RoleQuery{Account: query[:end+1], Role: strings.TrimSpace(role)}
+	}
+	if i := strings.Index(query, "/"); i >= 0 {
+		return RoleQuery{Account: strings.TrimSpace(query[:i]), Role: strings.TrimSpace(query[i+1:])}
+	}
+	return RoleQuery{Role: query}
+}
+
+// returns index of the slash closing regular expression that starts at index 0, escaped slashes are skipped
+func closingSlash(query string) int {
+
+	for i := 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case '/':
+			return i
+		}
+	}
+	return -1
+}
+
+func isRegexp(pattern string) bool {
+	return len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+func (q RoleQuery) String() string {
+
+	if q.Account == "" {
+		return q.Role
+	}
+	return q.Account + "/" + q.Role
+}
+
+// Returns all roles matching the query, sorted by account name and role name
+func (roles Roles) Find(query RoleQuery) (Roles, error) {
+
+	accountMatcher, err := newMatcher(query.Account)
+	if err != nil {
+		return nil, fmt.Errorf("account %s: %v", query.Account, err)
+	}
+	roleMatcher, err := newMatcher(query.Role)
+	if err != nil {
+		return nil, fmt.Errorf("role %s: %v", query.Role, err)
+	}
+
+	var found Roles
+	for _, role := range roles {
+		if !accountMatcher(role.Account.Name) && !accountMatcher(role.Account.Id) {
+			continue
+		}
+		if roleMatcher(role.Name) || role.Arn == query.Role {
+			found = append(found, role)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Account.Name != found[j].Account.Name {
+			return found[i].Account.Name < found[j].Account.Name
+		}
+		return found[i].Name < found[j].Name
+	})
+	return found, nil
+}
+
+// Returns the only role matching the query, AmbiguousRoleError if more roles match, or RoleNotFoundError
+// with closest roles as suggestions if none matches
+func (roles Roles) Select(query RoleQuery) (Role, error) {
+
+	found, err := roles.Find(query)
+	if err != nil {
+		return Role{}, err
+	}
+
+	switch len(found) {
+	case 1:
+		return found[0], nil
+	case 0:
+		return Role{}, &RoleNotFoundError{Query: query, Suggestions: roles.suggest(query)}
+	default:
+		return Role{}, &AmbiguousRoleError{Query: query, Candidates: found}
+	}
+}
+
+type AmbiguousRoleError struct {
+	Query      RoleQuery
+	Candidates Roles
+}
+
+func (e *AmbiguousRoleError) Error() string {
+	return fmt.Sprintf("%q matches %d roles: %s", e.Query, len(e.Candidates), roleNames(e.Candidates))
+}
+
+type RoleNotFoundError struct {
+	Query       RoleQuery
+	Suggestions Roles
+}
+
+func (e *RoleNotFoundError) Error() string {
+
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("no role matches %q", e.Query)
+	}
+	return fmt.Sprintf("no role matches %q, did you mean: %s", e.Query, roleNames(e.Suggestions))
+}
+
+func roleNames(roles Roles) string {
+
+	var names []string
+	for _, role := range roles {
+		names = append(names, fmt.Sprintf("%s/%s", role.Account.Name, role.Name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// returns matcher for account or role query field, empty field matches everything
+func newMatcher(pattern string) (func(string) bool, error) {
+
+	if pattern == "" {
+		return func(string) bool { return true }, nil
+	}
+
+	if isRegexp(pattern) {
+		// names are matched case insensitively, as plain names and glob patterns are
+		re, err := regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+
+	pattern = strings.ToLower(pattern)
+	if strings.ContainsAny(pattern, "*?[") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		return func(s string) bool {
+			ok, _ := path.Match(pattern, strings.ToLower(s))
+			return ok
+		}, nil
+	}
+	return func(s string) bool { return strings.ToLower(s) == pattern }, nil
+}
+
+// ranks roles by edit distance of account and role name to the query, no suggestions for glob or regexp queries
+func (roles Roles) suggest(query RoleQuery) Roles {
+
+	// edit distance to a glob pattern or regular expression is meaningless
+	if isPattern(query.Account) || isPattern(query.Role) {
+		return nil
+	}
+
+	type ranked struct {
+		role     Role
+		distance int
+	}
+
+	var candidates []ranked
+	for _, role := range roles {
+		distance, ok := 0, true
+		if query.Account != "" {
+			d := fuzzyDistance(query.Account, role.Account.Name)
+			if id := fuzzyDistance(query.Account, role.Account.Id); id < d {
+				d = id
+			}
+			distance, ok = distance+d, ok && withinTolerance(query.Account, d)
+		}
+		if query.Role != "" {
+			d := fuzzyDistance(query.Role, role.Name)
+			distance, ok = distance+d, ok && withinTolerance(query.Role, d)
+		}
+		if ok {
+			candidates = append(candidates, ranked{role, distance})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var suggestions Roles
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].role)
+	}
+	return suggestions
+}
+
+func isPattern(field string) bool {
+	return isRegexp(field) || strings.ContainsAny(field, "*?[")
+}
+
+// tolerates roughly one typo per three characters
+func withinTolerance(query string, distance int) bool {
+	return distance <= len(query)/3+1
+}
+
+// case insensitive levenshtein distance
+func fuzzyDistance(a, b string) int {
+
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(values ...int) int {
+
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
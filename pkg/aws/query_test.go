@@ -0,0 +1,106 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var queryRoles = Roles{
+	{Account: Account{Id: "111", Name: "prod-payments"}, Arn: "arn:aws:iam::111:role/Admin", Name: "Admin"},
+	{Account: Account{Id: "111", Name: "prod-payments"}, Arn: "arn:aws:iam::111:role/ReadOnly", Name: "ReadOnly"},
+	{Account: Account{Id: "222", Name: "prod-search"}, Arn: "arn:aws:iam::222:role/Admin", Name: "Admin"},
+	{Account: Account{Id: "333", Name: "lab"}, Arn: "arn:aws:iam::333:role/ADFS-Developers", Name: "ADFS-Developers"},
+}
+
+func TestParseRoleQuery(t *testing.T) {
+
+	assert.Equal(t, RoleQuery{Role: "Admin"}, ParseRoleQuery("Admin"))
+	assert.Equal(t, RoleQuery{Account: "prod-payments", Role: "Admin"}, ParseRoleQuery(" prod-payments / Admin "))
+	assert.Equal(t, RoleQuery{Role: "arn:aws:iam::111:role/Admin"}, ParseRoleQuery("arn:aws:iam::111:role/Admin"))
+	assert.Equal(t, RoleQuery{Account: "/^prod-.*$/", Role: "Admin"}, ParseRoleQuery("/^prod-.*$//Admin"))
+	assert.Equal(t, RoleQuery{Role: "/^Read/"}, ParseRoleQuery("/^Read/"))
+	assert.Equal(t, RoleQuery{Account: "lab", Role: "/^ADFS-.*$/"}, ParseRoleQuery("lab//^ADFS-.*$/"))
+	assert.Equal(t, RoleQuery{Account: "/prod/", Role: "Admin"}, ParseRoleQuery("/prod/Admin"))
+	assert.Equal(t, RoleQuery{Account: "/prod/", Role: "/^Admin$/"}, ParseRoleQuery("/prod//^Admin$/"))
+	assert.Equal(t, RoleQuery{Account: "/prod/", Role: "/^Admin$/"}, ParseRoleQuery("/prod///^Admin$/"))
+	assert.Equal(t, RoleQuery{Account: `/^prod\/eu$/`, Role: "Admin"}, ParseRoleQuery(`/^prod\/eu$/Admin`))
+	assert.Equal(t, RoleQuery{Role: `/^a\/b$/`}, ParseRoleQuery(`/^a\/b$/`))
+}
+
+func TestFindRoles(t *testing.T) {
+
+	for query, expected := range map[RoleQuery][]string{
+		{Account: "prod-payments", Role: "admin"}: {"arn:aws:iam::111:role/Admin"},
+		{Account: "222"}:                              {"arn:aws:iam::222:role/Admin"},
+		{Account: "prod-*", Role: "Admin"}:            {"arn:aws:iam::111:role/Admin", "arn:aws:iam::222:role/Admin"},
+		{Role: "/^(Read|ADFS-)/"}:                     {"arn:aws:iam::333:role/ADFS-Developers", "arn:aws:iam::111:role/ReadOnly"},
+		{Account: "/^PROD-pay/", Role: "/^admin$/"}:   {"arn:aws:iam::111:role/Admin"},
+		{Account: "PROD-SEARCH", Role: "ADM*"}:        {"arn:aws:iam::222:role/Admin"},
+		{Role: "arn:aws:iam::222:role/Admin"}:         {"arn:aws:iam::222:role/Admin"},
+		{Account: "prod-payments", Role: "PowerUser"}: nil,
+	} {
+		found, err := queryRoles.Find(query)
+		require.NoError(t, err, query.String())
+		var arns []string
+		for _, role := range found {
+			arns = append(arns, role.Arn)
+		}
+		assert.Equal(t, expected, arns, query.String())
+	}
+
+	_, err := queryRoles.Find(RoleQuery{Role: "/(/"})
+	assert.Error(t, err)
+	_, err = queryRoles.Find(RoleQuery{Account: "[prod"})
+	assert.Error(t, err)
+}
+
+func TestSelectRole(t *testing.T) {
+
+	role, err := queryRoles.Select(ParseRoleQuery("lab/ADFS-Developers"))
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::333:role/ADFS-Developers", role.Arn)
+
+	role, err = queryRoles.Select(ParseRoleQuery("/search/admin"))
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::222:role/Admin", role.Arn)
+
+	_, err = queryRoles.Select(ParseRoleQuery("Admin"))
+	require.IsType(t, &AmbiguousRoleError{}, err)
+	assert.Len(t, err.(*AmbiguousRoleError).Candidates, 2)
+	assert.EqualError(t, err, `"Admin" matches 2 roles: prod-payments/Admin, prod-search/Admin`)
+
+	_, err = queryRoles.Select(ParseRoleQuery("prod-paymnets/Admni"))
+	require.IsType(t, &RoleNotFoundError{}, err)
+	assert.EqualError(t, err, `no role matches "prod-paymnets/Admni", did you mean: prod-payments/Admin`)
+
+	_, err = queryRoles.Select(ParseRoleQuery("staging/PowerUser"))
+	assert.EqualError(t, err, `no role matches "staging/PowerUser"`)
+
+	// patterns are not compared by edit distance
+	for _, query := range []string{"^prod-.*$", "prod-paymnets/Admi?", "/^prod-paymnets$/Admin"} {
+		_, err = queryRoles.Select(ParseRoleQuery(query))
+		require.IsType(t, &RoleNotFoundError{}, err, query)
+		assert.Empty(t, err.(*RoleNotFoundError).Suggestions, query)
+	}
+}
+
+func TestFuzzyDistance(t *testing.T) {
+
+	assert.Equal(t, 0, fuzzyDistance("Admin", "admin"))
+	assert.Equal(t, 2, fuzzyDistance("Admni", "Admin"))
+	assert.Equal(t, 3, fuzzyDistance("", "abc"))
+}
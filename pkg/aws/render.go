@@ -0,0 +1,135 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Formats supported by Roles.Render and Accounts.Render
+const (
+	ListFormatTable = "table"
+	ListFormatJSON  = "json"
+	ListFormatYAML  = "yaml"
+	ListFormatCSV   = "csv"
+)
+
+// Role as listed by Roles.Render, saml assertion is not included
+type RoleRecord struct {
+	AccountId    string `json:"account_id" yaml:"account_id"`
+	AccountName  string `json:"account_name" yaml:"account_name"`
+	RoleName     string `json:"role_name" yaml:"role_name"`
+	RoleArn      string `json:"role_arn" yaml:"role_arn"`
+	PrincipalArn string `json:"principal_arn" yaml:"principal_arn"`
+}
+
+type AccountRecord struct {
+	AccountId   string `json:"account_id" yaml:"account_id"`
+	AccountName string `json:"account_name" yaml:"account_name"`
+}
+
+var (
+	roleColumns    = []string{"account_id", "account_name", "role_name", "role_arn", "principal_arn"}
+	accountColumns = []string{"account_id", "account_name"}
+)
+
+// Returns roles sorted by account name and role name
+func (roles Roles) Records() []RoleRecord {
+
+	sorted, _ := roles.Find(RoleQuery{})
+	records := []RoleRecord{}
+	for _, role := range sorted {
+		records = append(records, RoleRecord{
+			AccountId:    role.Account.Id,
+			AccountName:  role.Account.Name,
+			RoleName:     role.Name,
+			RoleArn:      role.Arn,
+			PrincipalArn: role.PrincipalArn,
+		})
+	}
+	return records
+}
+
+// Writes roles in one of ListFormat constants
+func (roles Roles) Render(w io.Writer, format string) error {
+
+	records := roles.Records()
+	rows := make([][]string, len(records))
+	for i, r := range records {
+		rows[i] = []string{r.AccountId, r.AccountName, r.RoleName, r.RoleArn, r.PrincipalArn}
+	}
+	return render(w, format, records, roleColumns, rows)
+}
+
+func (accounts Accounts) Records() []AccountRecord {
+
+	records := []AccountRecord{}
+	for _, account := range accounts {
+		records = append(records, AccountRecord{AccountId: account.Id, AccountName: account.Name})
+	}
+	return records
+}
+
+// Writes accounts in one of ListFormat constants
+func (accounts Accounts) Render(w io.Writer, format string) error {
+
+	records := accounts.Records()
+	rows := make([][]string, len(records))
+	for i, r := range records {
+		rows[i] = []string{r.AccountId, r.AccountName}
+	}
+	return render(w, format, records, accountColumns, rows)
+}
+
+// records are used for structured formats, columns and rows for csv and table
+func render(w io.Writer, format string, records interface{}, columns []string, rows [][]string) error {
+
+	switch strings.ToLower(format) {
+	case ListFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case ListFormatYAML, "yml":
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(records); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case ListFormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(columns)
+		writer.WriteAll(rows)
+		return writer.Error()
+	case ListFormatTable, "":
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		header := make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = strings.ToUpper(strings.ReplaceAll(c, "_", " "))
+		}
+		fmt.Fprintln(writer, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+		return writer.Flush()
+	default:
+		return fmt.Errorf("unknown list format %q", format)
+	}
+}
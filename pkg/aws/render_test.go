@@ -0,0 +1,79 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+var renderRoles = Roles{
+	{Account: Account{Id: "222", Name: "prod"}, Arn: "arn:aws:iam::222:role/Admin", Name: "Admin",
+		PrincipalArn: "arn:aws:iam::222:saml-provider/ADFS", SamlAssertion: "secret-assertion"},
+	{Account: Account{Id: "111", Name: "lab"}, Arn: "arn:aws:iam::111:role/Developer, Ops", Name: "Developer, Ops",
+		PrincipalArn: "arn:aws:iam::111:saml-provider/ADFS", SamlAssertion: "secret-assertion"},
+}
+
+func TestRenderRolesTableAndCSV(t *testing.T) {
+
+	var b bytes.Buffer
+	require.NoError(t, renderRoles.Render(&b, ListFormatTable))
+	assert.Equal(t, ""+
+		"ACCOUNT ID  ACCOUNT NAME  ROLE NAME       ROLE ARN                              PRINCIPAL ARN\n"+
+		"111         lab           Developer, Ops  arn:aws:iam::111:role/Developer, Ops  arn:aws:iam::111:saml-provider/ADFS\n"+
+		"222         prod          Admin           arn:aws:iam::222:role/Admin           arn:aws:iam::222:saml-provider/ADFS\n",
+		b.String())
+
+	b.Reset()
+	require.NoError(t, renderRoles.Render(&b, ListFormatCSV))
+	assert.Equal(t, ""+
+		"account_id,account_name,role_name,role_arn,principal_arn\n"+
+		`111,lab,"Developer, Ops","arn:aws:iam::111:role/Developer, Ops",arn:aws:iam::111:saml-provider/ADFS`+"\n"+
+		"222,prod,Admin,arn:aws:iam::222:role/Admin,arn:aws:iam::222:saml-provider/ADFS\n",
+		b.String())
+}
+
+func TestRenderRolesJSONAndYAML(t *testing.T) {
+
+	for format, unmarshal := range map[string]func([]byte, interface{}) error{
+		ListFormatJSON: json.Unmarshal,
+		ListFormatYAML: yaml.Unmarshal,
+	} {
+		var b bytes.Buffer
+		require.NoError(t, renderRoles.Render(&b, format))
+		assert.NotContains(t, b.String(), "secret-assertion", format)
+
+		var records []RoleRecord
+		require.NoError(t, unmarshal(b.Bytes(), &records), format)
+		assert.Equal(t, renderRoles.Records(), records, format)
+		assert.Equal(t, "lab", records[0].AccountName, format)
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, Roles{}.Render(&b, ListFormatJSON))
+	assert.Equal(t, "[]\n", b.String())
+}
+
+func TestRenderAccounts(t *testing.T) {
+
+	var b bytes.Buffer
+	require.NoError(t, renderRoles.Accounts().Render(&b, ListFormatJSON))
+	assert.JSONEq(t, `[{"account_id":"111","account_name":"lab"},{"account_id":"222","account_name":"prod"}]`, b.String())
+
+	assert.EqualError(t, renderRoles.Accounts().Render(&b, "xml"), `unknown list format "xml"`)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Returns AWS_SHARED_CREDENTIALS_FILE, or ~/.aws/credentials
+func DefaultSharedCredentialsPath() string {
+
+	if path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".aws", "credentials")
+	}
+	return filepath.Join(home, ".aws", "credentials")
+}
+
+// Writes credentials to profile of aws shared credentials file, section of the profile is replaced, other profiles
+// and comments are kept. File is created if it does not exist
+func (c Credentials) WriteSharedCredentials(path, profile string) error {
+
+	if strings.TrimSpace(profile) == "" || strings.ContainsAny(profile, "[]\r\n") {
+		return fmt.Errorf("invalid credentials profile %q", profile)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read shared credentials: %v", err)
+	}
+
+	section := []string{
+		"[" + profile + "]",
+		"aws_access_key_id = " + c.AccessKeyId,
+		"aws_secret_access_key = " + c.SecretAccessKey,
+		"aws_session_token = " + c.SessionToken,
+	}
+	if !c.Expiration.IsZero() {
+		// not read by sdks, tells when the profile needs new login
+		section = append(section, "expiration = "+c.Expiration.UTC().Format(time.RFC3339))
+	}
+
+	var lines []string
+	inProfile, written := false, false
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\r\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			// blank line of the replaced section separates it from the next one
+			if inProfile && lines[len(lines)-1] != "" {
+				lines = append(lines, "")
+			}
+			inProfile = strings.TrimSpace(trimmed[1:len(trimmed)-1]) == profile
+			if inProfile && !written {
+				lines = append(lines, section...)
+				written = true
+			}
+		}
+		if !inProfile && (len(lines) > 0 || trimmed != "") {
+			lines = append(lines, line)
+		}
+	}
+	if !written {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, section...)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("write shared credentials: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return fmt.Errorf("write shared credentials: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSharedCredentials(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".aws", "credentials")
+
+	require.NoError(t, testCredentials.WriteSharedCredentials(path, "lab"))
+	require.NoError(t, ioutil.WriteFile(path, []byte(`# managed by hand
+[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = default-secret
+
+[lab]
+aws_access_key_id = ASIAOLD
+aws_secret_access_key = old-secret
+aws_session_token = old-token
+
+[prod]
+aws_access_key_id = AKIAPROD
+aws_secret_access_key = prod-secret
+`), 0600))
+
+	updated := testCredentials
+	updated.AccessKeyId = "ASIANEW"
+	require.NoError(t, updated.WriteSharedCredentials(path, "lab"))
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `# managed by hand
+[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = default-secret
+
+[lab]
+aws_access_key_id = ASIANEW
+aws_secret_access_key = se'cr\et
+aws_session_token = to+ken/==
+expiration = 2019-11-01T20:26:47Z
+
+[prod]
+aws_access_key_id = AKIAPROD
+aws_secret_access_key = prod-secret
+`, string(b))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// sdk reads the written profile
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithSharedCredentialsFiles([]string{path}),
+		config.WithSharedConfigFiles([]string{}), config.WithSharedConfigProfile("lab"))
+	require.NoError(t, err)
+	creds, err := cfg.Credentials.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ASIANEW", creds.AccessKeyID)
+	assert.Equal(t, "to+ken/==", creds.SessionToken)
+
+	assert.EqualError(t, testCredentials.WriteSharedCredentials(path, "a]b"), `invalid credentials profile "a]b"`)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// AssumeRoleWithSAML is served by the global sts endpoint, region is needed only to resolve it
+const defaultSTSRegion = "us-east-1"
+
+// sts regions of aws partitions, partitions without global endpoint need region of the partition
+var partitionSTSRegions = map[string]string{
+	"aws":        defaultSTSRegion,
+	"aws-us-gov": "us-gov-west-1",
+	"aws-cn":     "cn-north-1",
+}
+
+// returns sts region of the partition of role arn, e.g. 'cn-north-1' for 'arn:aws-cn:iam::123456789:role/Admin'
+func roleSTSRegion(roleArn string) string {
+	return partitionSTSRegions[arnPartition(roleArn)]
+}
+
+type AssumeRoleWithSAMLInput struct {
+	PrincipalArn  string
+	RoleArn       string
+	SAMLAssertion string
+	Duration      time.Duration
+	Policy        string
+	PolicyArns    []string
+}
+
+// Exchanges saml assertion for temporary aws credentials, default implementation is returned by NewSTSClient,
+// see awstest package for in-memory fake
+type STSClient interface {
+	AssumeRoleWithSAML(ctx context.Context, input AssumeRoleWithSAMLInput) (Credentials, error)
+}
+
+type sdkSTSClient struct {
+	client *sts.Client
+}
+
+// Returns aws sdk backed sts client with anonymous credentials, empty region defaults to us-east-1.
+// The client is isolated from shared aws config files, AWS_PROFILE and the environment, inheritNetworkSettings uses
+// AWS_CA_BUNDLE and proxy set in the environment
+func NewSTSClient(region string, inheritNetworkSettings bool) (STSClient, error) {
+
+	cfg, err := newSTSConfig(context.Background(), region, inheritNetworkSettings)
+	if err != nil {
+		return nil, fmt.Errorf("sts config: %v", err)
+	}
+	return sdkSTSClient{client: sts.NewFromConfig(cfg)}, nil
+}
+
+func (c sdkSTSClient) AssumeRoleWithSAML(ctx context.Context, input AssumeRoleWithSAMLInput) (Credentials, error) {
+
+	stsInput := &sts.AssumeRoleWithSAMLInput{
+		PrincipalArn:    aws.String(input.PrincipalArn),
+		RoleArn:         aws.String(input.RoleArn),
+		SAMLAssertion:   aws.String(input.SAMLAssertion),
+		DurationSeconds: aws.Int32(int32(input.Duration / time.Second)),
+	}
+	if input.Policy != "" {
+		stsInput.Policy = aws.String(input.Policy)
+	}
+	for _, arn := range input.PolicyArns {
+		stsInput.PolicyArns = append(stsInput.PolicyArns, types.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+
+	out, err := c.client.AssumeRoleWithSAML(ctx, stsInput)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return fromSTSOutput(out), nil
+}
+
+func fromSTSOutput(out *sts.AssumeRoleWithSAMLOutput) Credentials {
+
+	creds := fromSTSCredentials(out.Credentials)
+	if out.AssumedRoleUser != nil {
+		creds.AssumedRoleUserArn = aws.ToString(out.AssumedRoleUser.Arn)
+	}
+	creds.SourceIdentity = aws.ToString(out.SourceIdentity)
+	creds.Issuer = aws.ToString(out.Issuer)
+	creds.Subject = aws.ToString(out.Subject)
+	creds.PackedPolicySize = int(aws.ToInt32(out.PackedPolicySize))
+	return creds
+}
+
+func fromSTSCredentials(stsCreds *types.Credentials) Credentials {
+
+	if stsCreds == nil {
+		return Credentials{}
+	}
+
+	return Credentials{
+		AccessKeyId:     aws.ToString(stsCreds.AccessKeyId),
+		Expiration:      aws.ToTime(stsCreds.Expiration),
+		SecretAccessKey: aws.ToString(stsCreds.SecretAccessKey),
+		SessionToken:    aws.ToString(stsCreds.SessionToken),
+	}
+}
+
+// builds sts config with anonymous credentials (AssumeRoleWithSAML request is not signed), shared config files,
+// AWS_PROFILE and credentials from the environment are not loaded, so broken local aws setup does not affect login.
+// Inherited network settings are read from the environment only: AWS_CA_BUNDLE and HTTP_PROXY, HTTPS_PROXY, NO_PROXY.
+// Sdk retries are disabled, sts calls are retried by LoginOptions.Retry only
+func newSTSConfig(ctx context.Context, region string, inheritNetworkSettings bool) (aws.Config, error) {
+
+	if region == "" {
+		region = defaultSTSRegion
+	}
+
+	var rootCAs *x509.CertPool
+	if path := os.Getenv("AWS_CA_BUNDLE"); inheritNetworkSettings && path != "" {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("AWS_CA_BUNDLE: %v", err)
+		}
+		// bundle is added to system CAs
+		if rootCAs, err = x509.SystemCertPool(); err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return aws.Config{}, fmt.Errorf("AWS_CA_BUNDLE %s does not contain PEM certificates", path)
+		}
+	}
+
+	return aws.Config{
+		Region:      region,
+		Credentials: aws.AnonymousCredentials{},
+		Retryer:     func() aws.Retryer { return aws.NopRetryer{} },
+		HTTPClient: awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			tr.Proxy = nil
+			if inheritNetworkSettings {
+				tr.Proxy = http.ProxyFromEnvironment
+			}
+			if rootCAs != nil {
+				if tr.TLSClientConfig == nil {
+					tr.TLSClientConfig = &tls.Config{}
+				}
+				tr.TLSClientConfig.RootCAs = rootCAs
+			}
+		}),
+	}, nil
+}
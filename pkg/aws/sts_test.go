@@ -0,0 +1,235 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewSTSConfigIgnoresAmbientConfiguration(t *testing.T) {
+
+	os.Setenv("AWS_PROFILE", "does-not-exist")
+	os.Setenv("AWS_CONFIG_FILE", "/does/not/exist")
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIA_TEST")
+	defer os.Unsetenv("AWS_PROFILE")
+	defer os.Unsetenv("AWS_CONFIG_FILE")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+
+	cfg, err := newSTSConfig(context.Background(), "", false)
+	require.NoError(t, err)
+
+	assert.Equal(t, "us-east-1", cfg.Region)
+	assert.Equal(t, aws.AnonymousCredentials{}, cfg.Credentials)
+
+	tr := cfg.HTTPClient.(*awshttp.BuildableClient).GetTransport()
+	assert.Nil(t, tr.Proxy)
+}
+
+func TestNewSTSConfigInheritsNetworkSettings(t *testing.T) {
+
+	os.Setenv("AWS_CA_BUNDLE", "/does/not/exist")
+	defer os.Unsetenv("AWS_CA_BUNDLE")
+
+	_, err := newSTSConfig(context.Background(), "", false)
+	require.NoError(t, err, "ca bundle is ignored unless network settings are inherited")
+
+	_, err = newSTSConfig(context.Background(), "", true)
+	require.Error(t, err)
+}
+
+func TestNewSTSConfigInheritsNetworkSettingsIgnoresSharedConfig(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("[profile broken]\nsource_profile = missing\nrole_arn = x\n"), 0600))
+
+	os.Setenv("AWS_PROFILE", "does-not-exist")
+	os.Setenv("AWS_CONFIG_FILE", configFile)
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/does/not/exist")
+	defer os.Unsetenv("AWS_PROFILE")
+	defer os.Unsetenv("AWS_CONFIG_FILE")
+	defer os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+
+	cfg, err := newSTSConfig(context.Background(), "", true)
+	require.NoError(t, err, "bad AWS_PROFILE is ignored with inherited network settings")
+	assert.Equal(t, aws.AnonymousCredentials{}, cfg.Credentials)
+	assert.NotNil(t, cfg.HTTPClient.(*awshttp.BuildableClient).GetTransport().Proxy, "proxy is taken from the environment")
+
+	os.Setenv("AWS_PROFILE", "broken")
+	_, err = newSTSConfig(context.Background(), "", true)
+	require.NoError(t, err)
+}
+
+func TestNewSTSConfigTrustsCABundle(t *testing.T) {
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(assumeRoleWithSAMLResponse))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "sts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	os.Setenv("AWS_CA_BUNDLE", bundle)
+	defer os.Unsetenv("AWS_CA_BUNDLE")
+
+	for inherit, trusted := range map[bool]bool{true: true, false: false} {
+		cfg, err := newSTSConfig(context.Background(), "", inherit)
+		require.NoError(t, err)
+		cfg.BaseEndpoint = aws.String(server.URL)
+		cfg.RetryMaxAttempts = 1
+		_, err = sdkSTSClient{client: sts.NewFromConfig(cfg)}.AssumeRoleWithSAML(context.Background(), AssumeRoleWithSAMLInput{
+			PrincipalArn: "arn:aws:iam::123456789:saml-provider/ADFS", RoleArn: "arn:aws:iam::123456789:role/ADFS-User",
+			SAMLAssertion: "c2FtbA==", Duration: time.Hour,
+		})
+		assert.Equal(t, trusted, err == nil, "inherit network settings: %v, error: %v", inherit, err)
+	}
+}
+
+func TestNewSTSConfigWithInvalidCABundle(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bundle := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(bundle, []byte("not a certificate"), 0600))
+
+	os.Setenv("AWS_CA_BUNDLE", bundle)
+	defer os.Unsetenv("AWS_CA_BUNDLE")
+
+	_, err = newSTSConfig(context.Background(), "eu-west-1", true)
+	assert.Error(t, err)
+}
+
+func TestSDKSTSClientAssumeRoleWithSAML(t *testing.T) {
+
+	var form url.Values
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(assumeRoleWithSAMLResponse))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	cfg, err := newSTSConfig(context.Background(), "", false)
+	require.NoError(t, err)
+	cfg.BaseEndpoint = aws.String(server.URL)
+	c := sdkSTSClient{client: sts.NewFromConfig(cfg)}
+
+	creds, err := c.AssumeRoleWithSAML(context.Background(), AssumeRoleWithSAMLInput{
+		PrincipalArn:  "arn:aws:iam::123456789:saml-provider/ADFS",
+		RoleArn:       "arn:aws:iam::123456789:role/ADFS-User",
+		SAMLAssertion: "c2FtbA==",
+		Duration:      30 * time.Minute,
+		Policy:        `{"Version":"2012-10-17"}`,
+		PolicyArns:    []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "AssumeRoleWithSAML", form.Get("Action"))
+	assert.Equal(t, "arn:aws:iam::123456789:role/ADFS-User", form.Get("RoleArn"))
+	assert.Equal(t, "1800", form.Get("DurationSeconds"))
+	assert.Equal(t, `{"Version":"2012-10-17"}`, form.Get("Policy"))
+	assert.Equal(t, "arn:aws:iam::aws:policy/ReadOnlyAccess", form.Get("PolicyArns.member.1.arn"))
+
+	assert.Equal(t, "ASIAEXAMPLE", creds.AccessKeyId)
+	assert.Equal(t, "secret", creds.SecretAccessKey)
+	assert.Equal(t, "token", creds.SessionToken)
+	assert.Equal(t, time.Date(2019, 11, 1, 20, 26, 47, 0, time.UTC), creds.Expiration)
+	assert.Equal(t, "arn:aws:sts::123456789:assumed-role/ADFS-User/dicktracy@test.com", creds.AssumedRoleUserArn)
+	assert.Equal(t, "dicktracy", creds.SourceIdentity)
+	assert.Equal(t, "http://sso.test.biz/adfs/services/trust", creds.Issuer)
+	assert.Equal(t, `SEA\dicktracy`, creds.Subject)
+	assert.Equal(t, 6, creds.PackedPolicySize)
+}
+
+func TestSDKSTSClientIsRetriedByPolicyOnly(t *testing.T) {
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`<ErrorResponse><Error><Type>Receiver</Type><Code>ServiceUnavailable</Code></Error></ErrorResponse>`))
+	}))
+	defer server.Close()
+
+	cfg, err := newSTSConfig(context.Background(), "", false)
+	require.NoError(t, err)
+	cfg.BaseEndpoint = aws.String(server.URL)
+	role := Role{Arn: "arn:aws:iam::123456789:role/ADFS-User", PrincipalArn: "arn:aws:iam::123456789:saml-provider/ADFS",
+		SamlAssertion: "c2FtbA=="}
+
+	_, err = role.LoginWithOptions(LoginOptions{STSClient: sdkSTSClient{client: sts.NewFromConfig(cfg)},
+		Retry: retry.Policy{MaxAttempts: 2, Backoff: time.Millisecond}})
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestRoleSTSRegion(t *testing.T) {
+
+	assert.Equal(t, "us-east-1", roleSTSRegion("arn:aws:iam::123456789:role/Admin"))
+	assert.Equal(t, "us-gov-west-1", roleSTSRegion("arn:aws-us-gov:iam::123456789:role/Admin"))
+	assert.Equal(t, "cn-north-1", roleSTSRegion("arn:aws-cn:iam::123456789:role/Admin"))
+	assert.Equal(t, "us-east-1", roleSTSRegion("Admin"), "unknown partition")
+}
+
+var assumeRoleWithSAMLResponse = `<AssumeRoleWithSAMLResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithSAMLResult>
+    <Issuer>http://sso.test.biz/adfs/services/trust</Issuer>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789:assumed-role/ADFS-User/dicktracy@test.com</Arn>
+      <AssumedRoleId>AROAEXAMPLE:dicktracy@test.com</AssumedRoleId>
+    </AssumedRoleUser>
+    <Credentials>
+      <AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2019-11-01T20:26:47Z</Expiration>
+    </Credentials>
+    <Audience>https://signin.aws.amazon.com/saml</Audience>
+    <SubjectType>persistent</SubjectType>
+    <PackedPolicySize>6</PackedPolicySize>
+    <NameQualifier>SbdGOnUkh1i4+ETHeFxFaH4i9Ps=</NameQualifier>
+    <SourceIdentity>dicktracy</SourceIdentity>
+    <Subject>SEA\dicktracy</Subject>
+  </AssumeRoleWithSAMLResult>
+  <ResponseMetadata>
+    <RequestId>c6104cbe-af31-11e0-8154-cbc7ccf896c7</RequestId>
+  </ResponseMetadata>
+</AssumeRoleWithSAMLResponse>`
@@ -0,0 +1,184 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/mfa/duo"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/saml"
+	"net/http"
+	"time"
+)
+
+var _ aws.Authenticator = Authenticator{}
+
+// Non-interactive ADFS login used to refresh credentials by aws.CredentialsProvider
+type Authenticator struct {
+	AdfsHost string
+	// sign-on endpoint, defaults to idpinitiatedsignon.aspx with aws relying party
+	SignOn SignOn
+	// login form selector and user and password field names, defaults to first form with password input
+	LoginForm LoginForm
+	User      string
+	Password  string
+	// windows integrated authentication with kerberos ticket instead of user and password, nil to use login form
+	Kerberos *Kerberos
+	// windows integrated authentication with NTLMv2 using User ('DOMAIN\user') and Password instead of login form,
+	// with Kerberos it is used when ADFS offers only NTLM
+	NTLM bool
+	// logs in with client certificate of Transport on ADFS certificate authentication endpoint instead of user and
+	// password or windows integrated authentication
+	CertificateAuth bool
+	// http client timeout, defaults to 20 seconds
+	Timeout time.Duration
+	// proxy, CA bundle, client certificate and TLS settings of ADFS and Duo requests
+	Transport TransportOptions
+	// records the login to HAR file, nil if login is not traced
+	Trace *Trace
+	// retries login page and duo status requests on transient failures, zero value does not retry
+	Retry retry.Policy
+	// receives debug events of the login steps, *slog.Logger can be used, nil if events are not logged
+	Logger debuglog.Logger
+	// duo device and factor used when ADFS requires MFA e.g. 'phone1' and 'Duo Push', empty if MFA is not used
+	MFADevice string
+	MFAFactor string
+	// returns passcode for 'Passcode' factor e.g. from otp generator, not used with other factors
+	Passcode func() (string, error)
+}
+
+func (a Authenticator) LoadAWSRoles() (aws.Roles, error) {
+
+	c, err := a.newClient()
+	if err != nil {
+		return nil, err
+	}
+	return a.loadAWSRoles(c)
+}
+
+// Logs in and returns duo devices when ADFS requires MFA, for interactive logins that select device and factor.
+// MFADevice, MFAFactor and Passcode are not used
+func (a Authenticator) LoadDuoDevices() (duo.Devices, error) {
+
+	c, err := a.newClient()
+	if err != nil {
+		return nil, err
+	}
+	return a.loadDuoDevices(c)
+}
+
+func (a Authenticator) newClient() (*http.Client, error) {
+
+	timeout := a.Timeout
+	if timeout == 0 {
+		timeout = 20 * time.Second
+	}
+	transport := a.Transport
+	transport.DisableHTTP2 = transport.DisableHTTP2 || a.NTLM
+	// password is redacted by value too, form field names of customised themes need not look like password
+	var secretFields []string
+	if a.LoginForm.PasswordField != "" {
+		secretFields = append(secretFields, a.LoginForm.PasswordField)
+	}
+	return NewClient(ClientOptions{Timeout: timeout, Trace: a.Trace, SecretFields: secretFields, SecretValues: []string{a.Password},
+		Transport: transport, Retry: a.Retry, Logger: a.Logger})
+}
+
+// logs in with client that is built from the options, or passed to Load* functions
+func (a Authenticator) loadAWSRoles(c *http.Client) (aws.Roles, error) {
+
+	logger := debuglog.OrNop(a.Logger)
+	if a.MFAFactor == "" {
+		c, requester, err := a.signOn(c, logger)
+		if err != nil {
+			return nil, err
+		}
+		return saml.LoadAWSRolesWithLogger(c, requester, logger)
+	}
+
+	devices, err := a.loadDuoDevices(c)
+	if err != nil {
+		return nil, err
+	}
+	device, ok := devices[a.MFADevice]
+	if !ok {
+		return nil, fmt.Errorf("duo device %s not found", a.MFADevice)
+	}
+	factor, ok := device.Factors[a.MFAFactor]
+	if !ok {
+		return nil, fmt.Errorf("duo device %s does not have %s factor", a.MFADevice, a.MFAFactor)
+	}
+
+	var passcode string
+	if a.Passcode != nil {
+		if passcode, err = a.Passcode(); err != nil {
+			return nil, fmt.Errorf("duo passcode: %v", err)
+		}
+	}
+	return factor.LoadAWSRoles(passcode)
+}
+
+func (a Authenticator) loadDuoDevices(c *http.Client) (duo.Devices, error) {
+
+	logger := debuglog.OrNop(a.Logger)
+	c, requester, err := a.signOn(c, logger)
+	if err != nil {
+		return nil, err
+	}
+	return duo.LoginWithLogger(c, requester, logger)
+}
+
+// returns sign-on request of the configured authentication, login form and wia or certificate request all submit
+// the sign-on that returns saml assertion or duo form. Wia wraps transport of the client
+func (a Authenticator) signOn(c *http.Client, logger debuglog.Logger) (*http.Client, saml.AssertionRequester, error) {
+
+	if a.CertificateAuth {
+		request, err := newCertAuthRequest(a.AdfsHost, a.SignOn, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c, request, nil
+	}
+
+	auths := a.wiaAuths()
+	if len(auths) == 0 {
+		loginForm, err := loadSignOnLoginForm(c, a.AdfsHost, a.SignOn, a.LoginForm, a.User, a.Password, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c, loginForm, nil
+	}
+
+	wiaClient, request, err := newWIALogin(a.AdfsHost, a.SignOn, c, auths...)
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Debug("windows integrated authentication", "kerberos", a.Kerberos != nil, "ntlm", a.NTLM)
+	return wiaClient, request, nil
+}
+
+// kerberos answers Negotiate challenge first, NTLM wraps it and answers NTLM challenge that is left
+func (a Authenticator) wiaAuths() []wiaAuth {
+
+	var auths []wiaAuth
+	if a.Kerberos != nil {
+		auths = append(auths, a.Kerberos.wiaAuth())
+	}
+	if a.NTLM {
+		auths = append(auths, ntlmAuth(a.User, a.Password))
+	}
+	return auths
+}
@@ -0,0 +1,84 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuthenticatorLogsSteps(t *testing.T) {
+
+	server := newFormLoginServer(t)
+	defer server.Close()
+
+	var b bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	roles, err := Authenticator{AdfsHost: server.URL, User: "dicktracy", Password: "test-password", Logger: logger}.LoadAWSRoles()
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+
+	events := b.String()
+	assert.Contains(t, events, `msg="login form found" url=`+server.URL+"/adfs/ls/idpinitiatedsignon.aspx")
+	assert.Contains(t, events, "user_fields=[UserName] password_fields=[Password]")
+	assert.Contains(t, events, `msg="login submitted"`)
+	assert.Contains(t, events, `msg="saml assertion received"`)
+	assert.Contains(t, events, `msg="saml roles loaded" roles=1`)
+	for _, secret := range []string{"test-password", "session-cookie", testSamlResponse} {
+		assert.NotContains(t, events, secret)
+	}
+}
+
+func TestAuthenticatorRetriesLoginPageOnly(t *testing.T) {
+
+	server := newFormLoginServer(t)
+	defer server.Close()
+
+	// every request fails once with service unavailable
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	reverseProxy := httputil.NewSingleHostReverseProxy(target)
+	var requests []string
+	failed := make(map[string]bool)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+		requests = append(requests, key)
+		if !failed[key] {
+			failed[key] = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		reverseProxy.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	retry := retry.Policy{MaxAttempts: 3, Backoff: time.Millisecond}
+	_, err = Authenticator{AdfsHost: proxy.URL, User: "dicktracy", Password: "test-password", Retry: retry}.LoadAWSRoles()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status code 503")
+	assert.Equal(t, []string{
+		"GET /adfs/ls/idpinitiatedsignon.aspx",
+		"GET /adfs/ls/idpinitiatedsignon.aspx",
+		"POST /adfs/ls/idpinitiatedsignon.aspx",
+	}, requests, "password post is not retried")
+}
@@ -0,0 +1,161 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/html"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/mfa/duo"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ADFS authentication method selected by options form of the sign-in page
+const certAuthMethod = "CertificateAuthentication"
+
+// port of ADFS certificate authentication endpoint, when it does not use 'certauth.' host prefix
+const certAuthPort = "49443"
+
+// Logs in with client certificate instead of user and password (e.g. smart card or device certificate), client
+// needs to present the certificate, see TransportOptions. Certificate sign-in option of the login page is followed
+// to ADFS certificate authentication endpoint
+func LoadAWSRolesWithCertificate(adfsHost string, signOn SignOn, c *http.Client) (aws.Roles, error) {
+	return Authenticator{AdfsHost: adfsHost, SignOn: signOn, CertificateAuth: true}.loadAWSRoles(c)
+}
+
+// Logs in with client certificate when ADFS requires duo MFA after certificate authentication
+func LoadDuoDevicesWithCertificate(adfsHost string, signOn SignOn, c *http.Client) (duo.Devices, error) {
+	return Authenticator{AdfsHost: adfsHost, SignOn: signOn, CertificateAuth: true}.loadDuoDevices(c)
+}
+
+func newCertAuthRequest(adfsHost string, signOn SignOn, logger debuglog.Logger) (certAuthRequest, error) {
+
+	loginUrl, err := signOn.LoginURL(adfsHost)
+	if err != nil {
+		return certAuthRequest{}, err
+	}
+	return certAuthRequest{loginUrl: loginUrl, logger: debuglog.OrNop(logger)}, nil
+}
+
+// sign-on request of certificate authentication, response contains saml assertion form (or duo form)
+type certAuthRequest struct {
+	loginUrl string
+	logger   debuglog.Logger
+}
+
+func (r certAuthRequest) Submit(c *http.Client) (*http.Response, error) {
+
+	loginRequest, err := http.NewRequest(http.MethodGet, r.loginUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(retry.Request(loginRequest))
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.FollowInterstitials(c, resp, isSignOnPage)
+	if err != nil {
+		return nil, err
+	}
+	request, err := certAuthOption(doc)
+	if err != nil {
+		return nil, err
+	}
+	r.logger.Debug("certificate sign-in found", "url", debuglog.URL(doc.Url), "method", request.Method)
+
+	// tls handshake with client certificate is done by the certificate authentication endpoint ADFS redirects to
+	if resp, err = c.Do(request); err != nil {
+		return nil, fmt.Errorf("certificate authentication: %v", err)
+	}
+	if resp.Request.URL.Host == doc.Url.Host {
+		return resp, nil
+	}
+	r.logger.Debug("certificate authenticated", "url", debuglog.URL(resp.Request.URL), "status", resp.StatusCode)
+
+	// endpoint posts the result back to the sign-on host
+	certDoc, err := html.LoadDocument(resp)
+	if err != nil {
+		return nil, fmt.Errorf("certificate authentication %s: %v", debuglog.URL(resp.Request.URL), err)
+	}
+	form := certDoc.Find("form").First()
+	if form.Length() == 0 {
+		return nil, fmt.Errorf("certificate authentication %s did not return to adfs", debuglog.URL(resp.Request.URL))
+	}
+	f, err := html.LoadForm(certDoc.Url, form)
+	if err != nil {
+		return nil, err
+	}
+	return f.Submit(c)
+}
+
+// returns request of certificate sign-in option: link to certificate authentication endpoint, or options form
+// submitted with CertificateAuthentication method
+func certAuthOption(doc *goquery.Document) (*http.Request, error) {
+
+	var link *url.URL
+	doc.Find("a[href]").EachWithBreak(func(_ int, a *goquery.Selection) bool {
+		href := strings.TrimSpace(a.AttrOr("href", ""))
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			return true
+		}
+		u, err := doc.Url.Parse(href)
+		if err != nil {
+			return true
+		}
+		if isCertAuthURL(u) || strings.Contains(strings.ToLower(a.Text()), "certificate") {
+			link = u
+		}
+		return link == nil
+	})
+	if link != nil {
+		return http.NewRequest(http.MethodGet, link.String(), nil)
+	}
+
+	option := doc.Find("[id], [onclick]").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return s.AttrOr("id", "") == certAuthMethod || strings.Contains(s.AttrOr("onclick", ""), certAuthMethod)
+	})
+	if option.Length() == 0 {
+		return nil, errors.New("login page does not offer certificate authentication")
+	}
+
+	// login form has AuthMethod input too, options form does not have password input
+	optionsForm := doc.Find("form#options")
+	if optionsForm.Length() == 0 {
+		optionsForm = doc.Find("form").FilterFunction(func(_ int, form *goquery.Selection) bool {
+			passwords := form.Find("input").FilterFunction(func(_ int, input *goquery.Selection) bool { return isPasswordInput(input) })
+			return form.Find("input[name=AuthMethod]").Length() != 0 && passwords.Length() == 0
+		})
+	}
+	if optionsForm.Length() == 0 {
+		return nil, errors.New("cannot find authentication options form for certificate authentication")
+	}
+
+	form, err := html.LoadForm(doc.Url, optionsForm.First())
+	if err != nil {
+		return nil, err
+	}
+	form.Values.Set("AuthMethod", certAuthMethod)
+	return form.NewRequest()
+}
+
+// certificate authentication endpoint is on 'certauth.' prefixed host or on port 49443 of the ADFS host
+func isCertAuthURL(u *url.URL) bool {
+	return strings.HasPrefix(strings.ToLower(u.Hostname()), "certauth.") || u.Port() == certAuthPort
+}
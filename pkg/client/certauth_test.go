@@ -0,0 +1,136 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// adfs stand-in that redirects certificate sign-in option to certificate authentication server requiring client
+// certificate, which posts the certificate subject back
+func newCertAuthServers(t *testing.T, clientCert testClientCert) (adfs, certAuth *httptest.Server) {
+
+	mux := http.NewServeMux()
+	adfs = httptest.NewTLSServer(mux)
+	certAuth = newClientCertServer(t, clientCert.cert, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><body onload="document.forms[0].submit()"><form method="POST" action="%s/adfs/ls/idpinitiatedsignon.aspx">
+<input type="hidden" name="wresult" value="%s" /></form></body></html>`, adfs.URL, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+
+	mux.HandleFunc("/adfs/ls/idpinitiatedsignon.aspx", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet:
+			w.Write([]byte(htmlAdfs2016WithCertificate))
+		case r.FormValue("AuthMethod") == certAuthMethod:
+			http.Redirect(w, r, certAuth.URL+"/adfs/ls/?client-request-id=1", http.StatusFound)
+		case r.FormValue("wresult") == "dicktracy":
+			fmt.Fprintf(w, `<html><body><form method="POST" action="/saml">
+<input type="hidden" name="SAMLResponse" value="%s" /></form></body></html>`, testSamlResponse)
+		default:
+			http.Error(w, "unexpected login", http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/saml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><form><fieldset>
+<div class="saml-account"><div class="saml-account-name">Account: lab (123456789)</div></div>
+</fieldset></form></body></html>`))
+	})
+	return adfs, certAuth
+}
+
+func TestAuthenticatorCertificateAuth(t *testing.T) {
+
+	clientCert := newTestClientCert(t)
+	adfs, certAuth := newCertAuthServers(t, clientCert)
+	defer adfs.Close()
+	defer certAuth.Close()
+
+	// both test servers use the same certificate
+	dir := tempDir(t)
+	transport := TransportOptions{
+		CABundle:           writeCABundle(t, dir, adfs),
+		ClientCert:         clientCert.writePKCS12(t, dir, "p12-password"),
+		ClientCertPassword: "p12-password",
+	}
+	roles, err := Authenticator{AdfsHost: adfs.URL, CertificateAuth: true, Transport: transport}.LoadAWSRoles()
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	assert.Equal(t, "lab", roles[0].Account.Name)
+
+	transport.ClientCert, transport.ClientCertPassword = "", ""
+	_, err = Authenticator{AdfsHost: adfs.URL, CertificateAuth: true, Transport: transport}.LoadAWSRoles()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate authentication")
+}
+
+func TestCertAuthOption(t *testing.T) {
+
+	for _, c := range []struct {
+		html     string
+		expected string
+	}{
+		{htmlAdfs2016WithCertificate, "POST https://sso.test.com/adfs/ls/idpinitiatedsignon.aspx?client-request-id=1 AuthMethod=CertificateAuthentication"},
+		{`<a href="https://certauth.sso.test.com/adfs/ls/?ctx=1">Other</a>`, "GET https://certauth.sso.test.com/adfs/ls/?ctx=1 "},
+		{`<a href="https://sso.test.com:49443/adfs/ls/?ctx=1">Other</a>`, "GET https://sso.test.com:49443/adfs/ls/?ctx=1 "},
+		{`<a href="#">Sign in with a certificate</a><a href="/adfs/ls/cert">Sign in with a certificate</a>`, "GET https://sso.test.com/adfs/ls/cert "},
+	} {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(c.html))
+		require.NoError(t, err)
+		doc.Url, _ = url.Parse("https://sso.test.com/adfs/ls/idpinitiatedsignon.aspx")
+
+		request, err := certAuthOption(doc)
+		require.NoError(t, err, c.html)
+		var body []byte
+		if request.Body != nil {
+			body, err = ioutil.ReadAll(request.Body)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, c.expected, request.Method+" "+request.URL.String()+" "+string(body))
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlAdfs2016))
+	require.NoError(t, err)
+	_, err = certAuthOption(doc)
+	assert.EqualError(t, err, "login page does not offer certificate authentication")
+}
+
+// sign-in page with authentication options, certificate option selects method in options form
+var htmlAdfs2016WithCertificate = `
+<html>
+    <body>
+        <form method="post" id="loginForm" action="/adfs/ls/idpinitiatedsignon.aspx?client-request-id=1">
+            <input id="userNameInput" name="UserName" type="email" value="" />
+            <input id="passwordInput" name="Password" type="password" />
+            <input id="optionForms" type="hidden" name="AuthMethod" value="FormsAuthentication" />
+        </form>
+        <div id="authOptions">
+            <form id="options" method="post" action="/adfs/ls/idpinitiatedsignon.aspx?client-request-id=1">
+                <input id="optionSelection" type="hidden" name="AuthMethod" />
+                <div class="idp" tabindex="0" role="button" onclick="Options.selectOption('CertificateAuthentication'); return false;">
+                    <span class="largeTextNoWrap indentNonCollapsible">Sign in using an X.509 certificate</span>
+                </div>
+            </form>
+        </div>
+    </body>
+</html>
+`
@@ -16,11 +16,12 @@ package client
 import (
 	"fmt"
 	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/html"
 	"github.com/HotelsDotCom/aws-adfs-login/pkg/mfa/duo"
-	"github.com/HotelsDotCom/aws-adfs-login/pkg/saml"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
 	"net/http"
 	"net/http/cookiejar"
-	"strings"
 	"time"
 )
 
@@ -35,11 +36,17 @@ func LoadAWSRolesWithTimeout(adfsHost, user, password string, timeout time.Durat
 }
 
 func LoadAWSRolesByClient(adfsHost, user, password string, client *http.Client) (aws.Roles, error) {
-	loginForm, err := loadLoginForm(client, getLoginUrl(adfsHost), user, password)
-	if err != nil {
-		return nil, fmt.Errorf("cannot load login form: %v", err)
-	}
-	return saml.LoadAWSRoles(client, loginForm)
+	return LoadAWSRolesWithSignOn(adfsHost, SignOn{}, user, password, client)
+}
+
+// Logs in using sign-on endpoint with custom login url, path or relying party
+func LoadAWSRolesWithSignOn(adfsHost string, signOn SignOn, user, password string, client *http.Client) (aws.Roles, error) {
+	return LoadAWSRolesWithLoginForm(adfsHost, signOn, LoginForm{}, user, password, client)
+}
+
+// Logs in using login form selected by css selector or with custom user and password field names
+func LoadAWSRolesWithLoginForm(adfsHost string, signOn SignOn, form LoginForm, user, password string, client *http.Client) (aws.Roles, error) {
+	return Authenticator{AdfsHost: adfsHost, SignOn: signOn, LoginForm: form, User: user, Password: password}.loadAWSRoles(client)
 }
 
 func LoadDuoDevices(adfsHost, user, password string) (duo.Devices, error) {
@@ -53,17 +60,29 @@ func LoadDuoDevicesWithTimeout(adfsHost, user, password string, timeout time.Dur
 }
 
 func LoadDuoDevicesWithClient(adfsHost, user, password string, c *http.Client) (duo.Devices, error) {
-	loginForm, err := loadLoginForm(c, getLoginUrl(adfsHost), user, password)
-	if err != nil {
-		return nil, fmt.Errorf("cannot load login form: %v", err)
-	}
-	return duo.Login(c, loginForm)
+	return LoadDuoDevicesWithSignOn(adfsHost, SignOn{}, user, password, c)
+}
+
+// Logs in using sign-on endpoint with custom login url, path or relying party
+func LoadDuoDevicesWithSignOn(adfsHost string, signOn SignOn, user, password string, c *http.Client) (duo.Devices, error) {
+	return LoadDuoDevicesWithLoginForm(adfsHost, signOn, LoginForm{}, user, password, c)
 }
 
-func getLoginUrl(adfsHost string) string {
-	return fmt.Sprintf(
-		"%s/adfs/ls/idpinitiatedsignon.aspx?loginToRp=urn:amazon:webservices",
-		strings.TrimSuffix(adfsHost, "/"))
+// Logs in using login form selected by css selector or with custom user and password field names
+func LoadDuoDevicesWithLoginForm(adfsHost string, signOn SignOn, form LoginForm, user, password string, c *http.Client) (duo.Devices, error) {
+	return Authenticator{AdfsHost: adfsHost, SignOn: signOn, LoginForm: form, User: user, Password: password}.loadDuoDevices(c)
+}
+
+func loadSignOnLoginForm(c *http.Client, adfsHost string, signOn SignOn, form LoginForm, user, password string, logger debuglog.Logger) (html.Form, error) {
+	loginUrl, err := signOn.LoginURL(adfsHost)
+	if err != nil {
+		return html.Form{}, err
+	}
+	loginForm, err := loadLoginForm(c, loginUrl, form, user, password, logger)
+	if err != nil {
+		return html.Form{}, fmt.Errorf("cannot load login form: %v", err)
+	}
+	return loginForm, nil
 }
 
 func newHttpClient() *http.Client {
@@ -72,7 +91,40 @@ func newHttpClient() *http.Client {
 }
 
 func newHttpClientWithTimeout(timeout time.Duration) *http.Client {
+	// default transport options do not load any files and cannot fail
+	c, _ := NewClient(ClientOptions{Timeout: timeout})
+	return c
+}
+
+// Options of the http client used for login
+type ClientOptions struct {
+	// http client timeout, zero means no timeout
+	Timeout time.Duration
+	// records requests and responses to HAR file for debugging, nil if login is not traced
+	Trace *Trace
+	// redacted in trace in addition to the default secrets: names of form fields and json keys e.g. custom password
+	// field, and values wherever they appear e.g. the password
+	SecretFields []string
+	SecretValues []string
+	// proxy, CA bundle, client certificate and TLS settings
+	Transport TransportOptions
+	// retries login page and duo status requests on transient failures, zero value does not retry
+	Retry retry.Policy
+	// receives debug events of retries, nil if they are not logged
+	Logger debuglog.Logger
+}
+
+// Returns http client for Load* functions that use custom client, fails if CA bundle or client certificate
+// cannot be loaded
+func NewClient(options ClientOptions) (*http.Client, error) {
+
+	transport, err := options.Transport.transport()
+	if err != nil {
+		return nil, err
+	}
 	// using the same client with cookie jar to persist session
 	jar, _ := cookiejar.New(nil)
-	return &http.Client{Jar: jar, Timeout: timeout}
+	// every attempt is traced
+	roundTripper := options.Retry.Transport(options.Trace.transport(transport, options.SecretFields, options.SecretValues), options.Logger)
+	return &http.Client{Jar: jar, Timeout: options.Timeout, Transport: roundTripper}, nil
 }
@@ -0,0 +1,175 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/mfa/duo"
+	krbclient "github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Kerberos credentials used for windows integrated authentication (SPNEGO) instead of user and password form
+type Kerberos struct {
+	// credentials cache file e.g. from kinit, defaults to KRB5CCNAME environment variable or /tmp/krb5cc_<uid>
+	CCache string
+	// keytab file and its principal e.g. 'user@EXAMPLE.COM' used to get ticket from KDC, overrides credentials cache
+	Keytab    string
+	Principal string
+	// krb5.conf with realm KDCs, defaults to KRB5_CONFIG environment variable or /etc/krb5.conf
+	Config string
+	// service principal of ADFS, defaults to 'HTTP/<adfs host name>'
+	SPN string
+}
+
+func LoadAWSRolesWithKerberos(adfsHost string, signOn SignOn, kerberos Kerberos, c *http.Client) (aws.Roles, error) {
+	return Authenticator{AdfsHost: adfsHost, SignOn: signOn, Kerberos: &kerberos}.loadAWSRoles(c)
+}
+
+// Logs in with kerberos when ADFS requires duo MFA after windows integrated authentication
+func LoadDuoDevicesWithKerberos(adfsHost string, signOn SignOn, kerberos Kerberos, c *http.Client) (duo.Devices, error) {
+	return Authenticator{AdfsHost: adfsHost, SignOn: signOn, Kerberos: &kerberos}.loadDuoDevices(c)
+}
+
+func (k Kerberos) wiaAuth() wiaAuth {
+	return func(base http.RoundTripper, host string) (http.RoundTripper, error) {
+
+		krb, err := k.newClient()
+		if err != nil {
+			return nil, fmt.Errorf("kerberos: %v", err)
+		}
+		spn := k.SPN
+		if spn == "" {
+			spn = "HTTP/" + host
+		}
+		return &negotiateTransport{base: base, host: host, krb: krb, spn: spn}, nil
+	}
+}
+
+func (k Kerberos) newClient() (*krbclient.Client, error) {
+
+	conf, err := k.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if k.Keytab != "" {
+		kt, err := keytab.Load(k.Keytab)
+		if err != nil {
+			return nil, fmt.Errorf("load keytab %s: %v", k.Keytab, err)
+		}
+		user, realm := k.Principal, conf.LibDefaults.DefaultRealm
+		if i := strings.LastIndex(k.Principal, "@"); i >= 0 {
+			user, realm = k.Principal[:i], k.Principal[i+1:]
+		}
+		if user == "" || realm == "" {
+			return nil, fmt.Errorf("keytab principal %q is not in user@REALM format", k.Principal)
+		}
+		cl := krbclient.NewWithKeytab(user, realm, kt, conf, krbclient.DisablePAFXFAST(true))
+		if err := cl.Login(); err != nil {
+			return nil, fmt.Errorf("login %s: %v", k.Principal, err)
+		}
+		return cl, nil
+	}
+
+	path, err := k.ccachePath()
+	if err != nil {
+		return nil, err
+	}
+	ccache, err := credentials.LoadCCache(path)
+	if err != nil {
+		return nil, fmt.Errorf("load credentials cache %s: %v", path, err)
+	}
+	cl, err := krbclient.NewFromCCache(ccache, conf, krbclient.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, fmt.Errorf("credentials cache %s: %v", path, err)
+	}
+	return cl, nil
+}
+
+// krb5.conf is optional with credentials cache that already holds ADFS ticket
+func (k Kerberos) loadConfig() (*krbconfig.Config, error) {
+
+	path := k.Config
+	if path == "" {
+		path = os.Getenv("KRB5_CONFIG")
+	}
+	if path == "" {
+		path = "/etc/krb5.conf"
+		if _, err := os.Stat(path); err != nil {
+			return krbconfig.New(), nil
+		}
+	}
+	conf, err := krbconfig.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("load config %s: %v", path, err)
+	}
+	return conf, nil
+}
+
+func (k Kerberos) ccachePath() (string, error) {
+
+	path := k.CCache
+	if path == "" {
+		path = os.Getenv("KRB5CCNAME")
+	}
+	if path == "" {
+		return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid()), nil
+	}
+	// only file caches can be read, KEYRING, KCM and other types are not supported
+	if i := strings.Index(path, ":"); i >= 0 {
+		if !strings.EqualFold(path[:i], "FILE") {
+			return "", fmt.Errorf("credentials cache %s: only FILE caches are supported", path)
+		}
+		path = path[i+1:]
+	}
+	return path, nil
+}
+
+// answers 'WWW-Authenticate: Negotiate' challenge by repeating the request with kerberos ticket,
+// tickets are sent only to the adfs host
+type negotiateTransport struct {
+	base http.RoundTripper
+	host string
+	krb  *krbclient.Client
+	spn  string
+}
+
+func (t *negotiateTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || challenge(resp, "Negotiate") == nil {
+		return resp, err
+	}
+	if !canAuthenticate(r, t.host) {
+		return resp, nil
+	}
+	discard(resp)
+
+	retry, err := cloneRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := spnego.SetSPNEGOHeader(t.krb, retry, t.spn); err != nil {
+		return nil, fmt.Errorf("kerberos negotiate %s: %v", t.spn, err)
+	}
+	return t.base.RoundTrip(retry)
+}
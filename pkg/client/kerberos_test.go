@@ -0,0 +1,226 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/jcmturner/goidentity/v6"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRealm = "TEST.COM"
+
+func TestLoadAWSRolesWithKerberos(t *testing.T) {
+
+	kdc := newTestKDC(t)
+	server := newWIAServer(t, kdc.serviceKeytab(t, "HTTP/127.0.0.1"))
+	defer server.Close()
+
+	ccache := kdc.writeCCache(t, "dicktracy", "HTTP/127.0.0.1")
+	roles, err := Authenticator{AdfsHost: server.URL, Kerberos: &Kerberos{CCache: "FILE:" + ccache}}.LoadAWSRoles()
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	assert.Equal(t, "arn:aws:iam::123456789:role/Admin", roles[0].Arn)
+	assert.Equal(t, "lab", roles[0].Account.Name)
+	assert.Equal(t, "dicktracy@"+testRealm, server.user)
+}
+
+func TestLoadAWSRolesWithKerberosTicketForOtherService(t *testing.T) {
+
+	kdc := newTestKDC(t)
+	server := newWIAServer(t, kdc.serviceKeytab(t, "HTTP/127.0.0.1"))
+	defer server.Close()
+
+	// ticket for other service cannot be used, and the client cannot reach the KDC to get the right one
+	ccache := kdc.writeCCache(t, "dicktracy", "HTTP/other.test.com")
+	_, err := LoadAWSRolesWithKerberos(server.URL, SignOn{}, Kerberos{CCache: ccache, SPN: "HTTP/other.test.com"}, newHttpClient())
+	assert.Error(t, err)
+	assert.Equal(t, "", server.user)
+}
+
+func TestNegotiateTransportSendsTicketOnlyToAdfsHost(t *testing.T) {
+
+	kdc := newTestKDC(t)
+	var authorization string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	kerberos := Kerberos{CCache: kdc.writeCCache(t, "dicktracy", "HTTP/127.0.0.1")}
+	c, _, err := newWIALogin("https://sso.test.com", SignOn{}, newHttpClient(), kerberos.wiaAuth())
+	require.NoError(t, err)
+	resp, err := c.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "", authorization)
+}
+
+func TestKerberosCCachePath(t *testing.T) {
+
+	os.Setenv("KRB5CCNAME", "FILE:/tmp/krb5cc_test")
+	defer os.Unsetenv("KRB5CCNAME")
+
+	path, err := Kerberos{}.ccachePath()
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/krb5cc_test", path)
+
+	_, err = Kerberos{CCache: "KEYRING:persistent:1000"}.ccachePath()
+	assert.Error(t, err)
+}
+
+type wiaServer struct {
+	*httptest.Server
+	user string
+}
+
+// adfs stand-in, sign-on page redirects to windows integrated authentication that requires kerberos ticket
+func newWIAServer(t *testing.T, kt *keytab.Keytab) *wiaServer {
+
+	server := &wiaServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/adfs/ls/idpinitiatedsignon.aspx", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "urn:amazon:webservices", r.URL.Query().Get("loginToRp"))
+		assert.Contains(t, r.UserAgent(), "Trident/7.0")
+		http.Redirect(w, r, "/adfs/ls/wia?client-request-id=1", http.StatusFound)
+	})
+	mux.Handle("/adfs/ls/wia", spnego.SPNEGOKRB5Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := goidentity.FromHTTPRequestContext(r); id != nil {
+			server.user = id.UserName() + "@" + id.Domain()
+		}
+		fmt.Fprintf(w, `<html><body><form method="POST" action="/saml">
+<input type="hidden" name="SAMLResponse" value="%s" /></form></body></html>`, testSamlResponse)
+	}), kt))
+	mux.HandleFunc("/saml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><form><fieldset>
+<div class="saml-account"><div class="saml-account-name">Account: lab (123456789)</div></div>
+</fieldset></form></body></html>`))
+	})
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+var testSamlResponse = base64.StdEncoding.EncodeToString([]byte(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">
+  <Assertion xmlns="urn:oasis:names:tc:SAML:2.0:assertion">
+    <AttributeStatement>
+      <Attribute Name="https://aws.amazon.com/SAML/Attributes/Role">
+        <AttributeValue>arn:aws:iam::123456789:saml-provider/ADFS,arn:aws:iam::123456789:role/Admin</AttributeValue>
+      </Attribute>
+    </AttributeStatement>
+  </Assertion>
+</samlp:Response>`))
+
+// KDC stand-in, issues tickets encrypted with keys of its keytab directly into credentials cache file
+type testKDC struct {
+	keytab *keytab.Keytab
+}
+
+func newTestKDC(t *testing.T) testKDC {
+	return testKDC{keytab: newTestKeytab(t, "krbtgt/"+testRealm, "HTTP/127.0.0.1", "HTTP/other.test.com")}
+}
+
+// returns keytab of the service, as exported from the KDC for the service host
+func (kdc testKDC) serviceKeytab(t *testing.T, principal string) *keytab.Keytab {
+	return newTestKeytab(t, principal)
+}
+
+func newTestKeytab(t *testing.T, principals ...string) *keytab.Keytab {
+
+	kt := keytab.New()
+	for _, principal := range principals {
+		require.NoError(t, kt.AddEntry(principal, testRealm, principal+"-password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	}
+	return kt
+}
+
+// writes credentials cache (version 4) with TGT and service ticket for the user
+func (kdc testKDC) writeCCache(t *testing.T, user string, services ...string) string {
+
+	client := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, user)
+	var b bytes.Buffer
+	write := func(v interface{}) { binary.Write(&b, binary.BigEndian, v) }
+	writeData := func(d []byte) {
+		write(uint32(len(d)))
+		b.Write(d)
+	}
+	writePrincipal := func(p types.PrincipalName) {
+		write(p.NameType)
+		write(uint32(len(p.NameString)))
+		writeData([]byte(testRealm))
+		for _, s := range p.NameString {
+			writeData([]byte(s))
+		}
+	}
+
+	// file format version 4 with empty header
+	b.Write([]byte{5, 4, 0, 0})
+	writePrincipal(client)
+
+	now := time.Now().Truncate(time.Second)
+	for _, service := range append([]string{"krbtgt/" + testRealm}, services...) {
+		server := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, service)
+		ticketFlags := types.NewKrbFlags()
+		types.SetFlag(&ticketFlags, flags.Initial)
+		ticket, key, err := messages.NewTicket(client, testRealm, server, testRealm, ticketFlags, kdc.keytab,
+			etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+		require.NoError(t, err)
+		ticketBytes, err := ticket.Marshal()
+		require.NoError(t, err)
+
+		writePrincipal(client)
+		writePrincipal(server)
+		write(uint16(key.KeyType))
+		writeData(key.KeyValue)
+		for _, ts := range []time.Time{now, now, now.Add(time.Hour), now.Add(time.Hour)} {
+			write(uint32(ts.Unix()))
+		}
+		write(uint8(0))
+		b.Write(append(ticketFlags.Bytes, make([]byte, 4)...)[:4])
+		// addresses, authorization data, ticket and second ticket
+		write(uint32(0))
+		write(uint32(0))
+		writeData(ticketBytes)
+		writeData(nil)
+	}
+
+	dir, err := ioutil.TempDir("", "krb5cc")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "krb5cc_"+strings.ReplaceAll(user, "/", "_"))
+	require.NoError(t, ioutil.WriteFile(path, b.Bytes(), 0600))
+	return path
+}
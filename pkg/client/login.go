@@ -15,69 +15,170 @@ package client
 
 import (
 	"errors"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
 	"github.com/HotelsDotCom/aws-adfs-login/pkg/html"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
 	"github.com/PuerkitoBio/goquery"
 	"net/http"
 	"strings"
 )
 
-func loadLoginForm(c *http.Client, url string, username, password string) (html.Form, error) {
+// Login form of the sign-on page, zero value selects the first form with password input and fills inputs
+// whose names contain 'user' or 'email' with user, and password input with password
+type LoginForm struct {
+	// css selector of the login form e.g. '#loginForm', for customised themes with more forms
+	Selector string
+	// names of user and password inputs e.g. 'UserName' and 'Password', for themes with unusual names
+	UserField     string
+	PasswordField string
+}
+
+func loadLoginForm(c *http.Client, url string, loginForm LoginForm, username, password string, logger debuglog.Logger) (html.Form, error) {
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return html.Form{}, err
+	}
+	r, err := c.Do(retry.Request(request))
+	if err != nil {
+		return html.Form{}, err
+	}
 
-	r, err := c.Get(url)
+	// proxies in front of adfs can return pages that auto-submit before the login page
+	doc, err := html.FollowInterstitials(c, r, isSignOnPage)
 	if err != nil {
 		return html.Form{}, err
 	}
 
-	loginFormSelection, err := selectLoginForm(r)
+	loginFormSelection, err := selectLoginForm(doc, loginForm.Selector)
 	if err != nil {
 		return html.Form{}, err
 	}
 
-	form, err := html.LoadForm(r.Request.URL, loginFormSelection)
+	form, err := html.LoadForm(doc.Url, loginFormSelection)
 	if err != nil {
 		return html.Form{}, err
 	}
 
-	// fill in user and password fields
-	for name := range form.Values {
+	userFields, passwordFields := loginForm.fields(loginFormSelection)
+	if len(passwordFields) == 0 {
+		return html.Form{}, errors.New("cannot find password field in the login form")
+	}
+	for _, name := range userFields {
+		form.Values.Set(name, username)
+	}
+	for _, name := range passwordFields {
+		form.Values.Set(name, password)
+	}
+	debuglog.OrNop(logger).Debug("login form found", "url", debuglog.URL(doc.Url), "action", debuglog.URL(form.Action), "method", form.Method,
+		"user_fields", userFields, "password_fields", passwordFields)
+	return form, nil
+}
+
+// returns names of user and password inputs, configured names take precedence over the heuristics
+func (f LoginForm) fields(formSelection *goquery.Selection) (userFields, passwordFields []string) {
+
+	if f.UserField != "" {
+		userFields = []string{f.UserField}
+	}
+	if f.PasswordField != "" {
+		passwordFields = []string{f.PasswordField}
+	}
+	if userFields != nil && passwordFields != nil {
+		return userFields, passwordFields
+	}
 
-		if strings.Contains(strings.ToLower(name), "user") ||
-			strings.Contains(strings.ToLower(name), "email") {
-			form.Values.Set(name, username)
+	// visible text inputs only, hidden inputs e.g. 'passwordReset' or 'userContext' are state of the page
+	inputs := formSelection.Find("input[name]").FilterFunction(func(_ int, input *goquery.Selection) bool {
+		switch strings.ToLower(input.AttrOr("type", "text")) {
+		case "text", "email", "password", "tel":
+			return true
 		}
-		if strings.Contains(strings.ToLower(name), "pass") {
-			form.Values.Set(name, password)
+		return false
+	})
+	heuristicPassword := inputs.FilterFunction(func(_ int, input *goquery.Selection) bool { return isPasswordInput(input) })
+	if heuristicPassword.Length() == 0 {
+		heuristicPassword = inputs.FilterFunction(func(_ int, input *goquery.Selection) bool { return hasPasswordName(input) })
+	}
+	heuristicUser := inputs.FilterFunction(func(_ int, input *goquery.Selection) bool {
+		name := strings.ToLower(input.AttrOr("name", ""))
+		if isPasswordInput(input) || strings.Contains(name, "pass") {
+			return false
 		}
+		return strings.Contains(name, "user") || strings.Contains(name, "email")
+	})
+
+	if userFields == nil {
+		userFields = heuristicUser.Map(inputName)
 	}
-	return form, nil
+	if passwordFields == nil {
+		passwordFields = heuristicPassword.Map(inputName)
+	}
+	return userFields, passwordFields
 }
 
-func selectLoginForm(r *http.Response) (*goquery.Selection, error) {
+func selectLoginForm(doc *goquery.Document, selector string) (*goquery.Selection, error) {
 
-	doc, err := html.LoadDocument(r)
-	if err != nil {
-		return nil, err
+	if selector != "" {
+		selection := doc.Find(selector)
+		if selection.Length() == 0 {
+			return nil, fmt.Errorf("cannot find login form %q in the response", selector)
+		}
+		// selector can point to element inside the form e.g. fieldset of the login
+		if !selection.First().Is("form") {
+			selection = selection.First().Closest("form")
+		}
+		if selection.Length() == 0 {
+			return nil, fmt.Errorf("login form selector %q does not select a form", selector)
+		}
+		return selection.First(), nil
 	}
 
+	// password type input is the strongest hint, forms that use text input for password are matched by name
 	var loginFormSelection *goquery.Selection
-	doc.Find("form").Each(func(i int, formDoc *goquery.Selection) {
-		if loginFormSelection != nil {
-			return
-		}
-		formDoc.Find("input").Each(func(_ int, inputDoc *goquery.Selection) {
-			if loginFormSelection != nil {
-				return
-			}
-			if name, ok := inputDoc.Attr("name"); ok {
-				if strings.Contains(strings.ToLower(name), "pass") {
-					loginFormSelection = formDoc
-				}
+	for _, isPassword := range []func(*goquery.Selection) bool{isPasswordInput, hasPasswordName} {
+		doc.Find("form").EachWithBreak(func(i int, formDoc *goquery.Selection) bool {
+			if formDoc.Find("input").FilterFunction(func(_ int, input *goquery.Selection) bool { return isPassword(input) }).Length() != 0 {
+				loginFormSelection = formDoc
 			}
+			return loginFormSelection == nil
 		})
-	})
+		if loginFormSelection != nil {
+			break
+		}
+	}
 
 	if loginFormSelection == nil {
 		return nil, errors.New("cannot find login form in the response")
 	}
 	return loginFormSelection, nil
 }
+
+// interstitial pages are followed until sign-on page: login form, authentication options, or saml assertion form
+// when adfs does not ask for login, the assertion form auto-posts to aws and is never followed
+func isSignOnPage(doc *goquery.Document) bool {
+
+	if doc.Find("input[name=SAMLResponse], input[name=AuthMethod]").Length() != 0 {
+		return true
+	}
+	return doc.Find("input").FilterFunction(func(_ int, input *goquery.Selection) bool {
+		return isPasswordInput(input) || hasPasswordName(input)
+	}).Length() != 0
+}
+
+func isPasswordInput(input *goquery.Selection) bool {
+	return strings.EqualFold(input.AttrOr("type", ""), "password")
+}
+
+// hidden inputs e.g. 'passwordReset' are not password fields
+func hasPasswordName(input *goquery.Selection) bool {
+	if strings.EqualFold(input.AttrOr("type", ""), "hidden") {
+		return false
+	}
+	return strings.Contains(strings.ToLower(input.AttrOr("name", "")), "pass")
+}
+
+func inputName(_ int, input *goquery.Selection) string {
+	return input.AttrOr("name", "")
+}
@@ -31,7 +31,7 @@ func TestLoadLoginFormFromInputWithTwoForms(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(handler))
 	defer server.Close()
 
-	loginForm, err := loadLoginForm(http.DefaultClient, server.URL, "test-user", "test-password")
+	loginForm, err := loadLoginForm(http.DefaultClient, server.URL, LoginForm{}, "test-user", "test-password", nil)
 	require.NoError(t, err)
 
 	expectedAction := fmt.Sprintf("%s/saml/ls/IdpInitiatedSignOn.aspx?loginToRp=urn:amazon:webservices", server.URL)
@@ -41,6 +41,124 @@ func TestLoadLoginFormFromInputWithTwoForms(t *testing.T) {
 	assert.Equal(t, "test-password", loginForm.Values.Get("Password"))
 }
 
+func TestLoadLoginFormFromAdfsThemes(t *testing.T) {
+
+	for _, theme := range []struct {
+		name      string
+		html      string
+		loginForm LoginForm
+		action    string
+		user      []string
+		password  []string
+		values    map[string]string
+	}{
+		{
+			name:     "adfs 2.0",
+			html:     htmlAdfs20,
+			action:   "/adfs/ls/?wa=wsignin1.0",
+			user:     []string{"ctl00$ContentPlaceHolder1$UsernameTextBox"},
+			password: []string{"ctl00$ContentPlaceHolder1$PasswordTextBox"},
+			values:   map[string]string{"__VIEWSTATE": "/wEPDwUKLTQ5", "__db": "15"},
+		},
+		{
+			name:     "adfs 2016 default theme",
+			html:     htmlAdfs2016,
+			action:   "/adfs/ls/idpinitiatedsignon.aspx?client-request-id=1",
+			user:     []string{"UserName"},
+			password: []string{"Password"},
+			values:   map[string]string{"AuthMethod": "FormsAuthentication"},
+		},
+		{
+			name:     "custom theme with password reset",
+			html:     htmlWithPasswordReset,
+			action:   "/adfs/ls/idpinitiatedsignon.aspx",
+			user:     []string{"UserName"},
+			password: []string{"Password"},
+			values:   map[string]string{"passwordReset": "", "userContext": "ctx"},
+		},
+		{
+			name:      "custom theme with change password form",
+			html:      htmlWithChangePassword,
+			loginForm: LoginForm{Selector: "#signIn fieldset", UserField: "login", PasswordField: "secret"},
+			action:    "/adfs/ls/signin",
+			user:      []string{"login"},
+			password:  []string{"secret"},
+			values:    map[string]string{"otp": ""},
+		},
+	} {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(theme.html))
+		}
+		server := httptest.NewServer(http.HandlerFunc(handler))
+
+		loginForm, err := loadLoginForm(http.DefaultClient, server.URL+"/adfs/ls/", theme.loginForm, "test-user", "test-password", nil)
+		server.Close()
+		require.NoError(t, err, theme.name)
+
+		assert.Equal(t, server.URL+theme.action, loginForm.Action.String(), theme.name)
+		assert.Equal(t, "POST", loginForm.Method, theme.name)
+		for _, name := range theme.user {
+			assert.Equal(t, "test-user", loginForm.Values.Get(name), theme.name)
+		}
+		for _, name := range theme.password {
+			assert.Equal(t, "test-password", loginForm.Values.Get(name), theme.name)
+		}
+		for name, value := range theme.values {
+			assert.Equal(t, value, loginForm.Values.Get(name), theme.name+" "+name)
+		}
+	}
+}
+
+func TestLoadLoginFormStopsAtSignOnPage(t *testing.T) {
+
+	for name, page := range map[string]string{
+		"saml assertion": `<input type="hidden" name="SAMLResponse" value="c2FtbA==" />`,
+		"options":        `<input type="hidden" name="AuthMethod" value="CertificateAuthentication" />`,
+	} {
+		var followed bool
+		mux := http.NewServeMux()
+		mux.HandleFunc("/adfs/ls/", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `<html><body onload="document.forms[0].submit()"><form method="POST" action="/next">%s</form></body></html>`, page)
+		})
+		mux.HandleFunc("/next", func(w http.ResponseWriter, r *http.Request) {
+			followed = true
+			w.Write([]byte(htmlAdfs2016))
+		})
+		server := httptest.NewServer(mux)
+
+		loadLoginForm(http.DefaultClient, server.URL+"/adfs/ls/", LoginForm{}, "test-user", "test-password", nil)
+		server.Close()
+		assert.False(t, followed, name)
+	}
+}
+
+func TestLoadLoginFormWithoutSelectedForm(t *testing.T) {
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(htmlAdfs2016))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	_, err := loadLoginForm(http.DefaultClient, server.URL, LoginForm{Selector: "#signIn"}, "test-user", "test-password", nil)
+	assert.EqualError(t, err, `cannot find login form "#signIn" in the response`)
+
+	_, err = loadLoginForm(http.DefaultClient, server.URL, LoginForm{Selector: "#header"}, "test-user", "test-password", nil)
+	assert.EqualError(t, err, `login form selector "#header" does not select a form`)
+}
+
+func TestLoadLoginFormWithoutPasswordField(t *testing.T) {
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(htmlWithChangePassword))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	_, err := loadLoginForm(http.DefaultClient, server.URL, LoginForm{Selector: "#signIn"}, "test-user", "test-password", nil)
+	assert.EqualError(t, err, "cannot find password field in the login form")
+}
+
 var htmlWithMultipleForms = `
 <html>
     <head></head>
@@ -59,3 +177,82 @@ var htmlWithMultipleForms = `
     </body>
 </html> 
 `
+
+// windows server 2008, asp.net page
+var htmlAdfs20 = `
+<html>
+    <body>
+        <form name="aspnetForm" method="post" action="?wa=wsignin1.0" id="aspnetForm">
+            <input type="hidden" name="__VIEWSTATE" id="__VIEWSTATE" value="/wEPDwUKLTQ5" />
+            <input type="hidden" name="__db" value="15" />
+            <div id="ctl00_ContentPlaceHolder1_FormsAuthArea">
+                <input name="ctl00$ContentPlaceHolder1$UsernameTextBox" type="text" id="ctl00_ContentPlaceHolder1_UsernameTextBox" />
+                <input name="ctl00$ContentPlaceHolder1$PasswordTextBox" type="password" id="ctl00_ContentPlaceHolder1_PasswordTextBox" />
+                <input type="submit" name="ctl00$ContentPlaceHolder1$SubmitButton" value="Sign In" id="ctl00_ContentPlaceHolder1_SubmitButton" />
+            </div>
+        </form>
+    </body>
+</html>
+`
+
+// windows server 2016 default theme, home realm discovery options form follows the login form
+var htmlAdfs2016 = `
+<html>
+    <body>
+        <div id="header">Sign in</div>
+        <div id="workArea">
+            <form method="post" id="loginForm" autocomplete="off" novalidate="novalidate" action="/adfs/ls/idpinitiatedsignon.aspx?client-request-id=1">
+                <div id="formsAuthenticationArea">
+                    <input id="userNameInput" name="UserName" type="email" value="" tabindex="1" placeholder="someone@example.com" />
+                    <input id="passwordInput" name="Password" type="password" tabindex="2" placeholder="Password" autocomplete="off" />
+                    <input type="checkbox" name="Kmsi" id="kmsiInput" value="true" tabindex="3" />
+                    <span id="submitButton" class="submit" tabindex="4" role="button">Sign in</span>
+                </div>
+                <input id="optionForms" type="hidden" name="AuthMethod" value="FormsAuthentication" />
+            </form>
+            <form id="options" method="post" action="/adfs/ls/idpinitiatedsignon.aspx?client-request-id=1">
+                <input id="optionSelection" type="hidden" name="AuthMethod" />
+            </form>
+        </div>
+    </body>
+</html>
+`
+
+// customised theme, password reset link is a form with hidden inputs before the login form
+var htmlWithPasswordReset = `
+<html>
+    <body>
+        <form id="resetForm" method="post" action="/adfs/portal/updatepassword">
+            <input type="hidden" name="passwordReset" value="true" />
+            <input type="hidden" name="username" value="" />
+        </form>
+        <form id="loginForm" method="POST" action="/adfs/ls/idpinitiatedsignon.aspx">
+            <input type="hidden" name="userContext" value="ctx" />
+            <input type="hidden" name="passwordReset" value="" />
+            <input name="UserName" type="text" />
+            <input name="Password" type="PASSWORD" />
+            <input type="hidden" name="AuthMethod" value="FormsAuthentication" />
+        </form>
+    </body>
+</html>
+`
+
+// customised theme, change password form comes first and sign in inputs have unusual names
+var htmlWithChangePassword = `
+<html>
+    <body>
+        <form id="changePassword" method="post" action="/adfs/portal/updatepassword">
+            <input name="UserName" type="text" />
+            <input name="OldPassword" type="password" />
+            <input name="NewPassword" type="password" />
+        </form>
+        <form id="signIn" method="post" action="/adfs/ls/signin">
+            <fieldset>
+                <input name="login" type="text" />
+                <input name="secret" type="text" />
+                <input name="otp" type="text" />
+            </fieldset>
+        </form>
+    </body>
+</html>
+`
@@ -0,0 +1,119 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/Azure/go-ntlmssp"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/mfa/duo"
+	"net/http"
+	"strings"
+)
+
+// Logs in with windows integrated authentication using NTLMv2, for ADFS that offers NTLM instead of login form.
+// User is in 'DOMAIN\user' format
+func LoadAWSRolesWithNTLM(adfsHost string, signOn SignOn, user, password string, c *http.Client) (aws.Roles, error) {
+	return Authenticator{AdfsHost: adfsHost, SignOn: signOn, User: user, Password: password, NTLM: true}.loadAWSRoles(c)
+}
+
+// Logs in with NTLM when ADFS requires duo MFA after windows integrated authentication
+func LoadDuoDevicesWithNTLM(adfsHost string, signOn SignOn, user, password string, c *http.Client) (duo.Devices, error) {
+	return Authenticator{AdfsHost: adfsHost, SignOn: signOn, User: user, Password: password, NTLM: true}.loadDuoDevices(c)
+}
+
+func ntlmAuth(user, password string) wiaAuth {
+	return func(base http.RoundTripper, host string) (http.RoundTripper, error) {
+
+		domain, name, err := parseNTLMUser(user)
+		if err != nil {
+			return nil, err
+		}
+		return &ntlmTransport{base: base, host: host, domain: domain, user: name, password: password}, nil
+	}
+}
+
+// splits 'DOMAIN\user', user principal name 'user@example.com' is accepted without domain
+func parseNTLMUser(user string) (domain, name string, err error) {
+
+	if i := strings.Index(user, `\`); i >= 0 {
+		domain, name = user[:i], user[i+1:]
+		if domain == "" || name == "" {
+			return "", "", fmt.Errorf(`ntlm user %q is not in DOMAIN\user format`, user)
+		}
+		return domain, name, nil
+	}
+	if strings.Contains(user, "@") {
+		return "", user, nil
+	}
+	return "", "", fmt.Errorf(`ntlm user %q is not in DOMAIN\user or user@domain format`, user)
+}
+
+// answers 'WWW-Authenticate: NTLM' challenge with negotiate and authenticate messages (NTLMv2), password is sent
+// only to the adfs host. NTLM authenticates connection, the handshake relies on keep-alive connection reuse
+type ntlmTransport struct {
+	base     http.RoundTripper
+	host     string
+	domain   string
+	user     string
+	password string
+}
+
+func (t *ntlmTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || challenge(resp, "NTLM") == nil {
+		return resp, err
+	}
+	if !canAuthenticate(r, t.host) {
+		return resp, nil
+	}
+	discard(resp)
+
+	negotiate, err := ntlmssp.NewNegotiateMessage("", "")
+	if err != nil {
+		return nil, fmt.Errorf("ntlm negotiate: %v", err)
+	}
+	resp, err = t.roundTripWithToken(r, negotiate)
+	if err != nil {
+		return nil, err
+	}
+	serverChallenge := challenge(resp, "NTLM")
+	if resp.StatusCode != http.StatusUnauthorized || len(serverChallenge) == 0 {
+		// server accepted negotiate message alone or rejected it
+		return resp, nil
+	}
+	discard(resp)
+
+	username := t.user
+	if t.domain != "" {
+		username = t.domain + `\` + t.user
+	}
+	authenticate, err := ntlmssp.NewAuthenticateMessage(serverChallenge, username, t.password, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ntlm authenticate: %v", err)
+	}
+	return t.roundTripWithToken(r, authenticate)
+}
+
+func (t *ntlmTransport) roundTripWithToken(r *http.Request, token []byte) (*http.Response, error) {
+
+	req, err := cloneRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "NTLM "+base64.StdEncoding.EncodeToString(token))
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,230 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/md4"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestLoadAWSRolesWithNTLM(t *testing.T) {
+
+	server := newNTLMServer(t, "SEA", "dicktracy", "test-password")
+	defer server.Close()
+
+	roles, err := Authenticator{AdfsHost: server.URL, User: `SEA\dicktracy`, Password: "test-password", NTLM: true}.LoadAWSRoles()
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	assert.Equal(t, "arn:aws:iam::123456789:role/Admin", roles[0].Arn)
+	assert.Equal(t, `SEA\dicktracy`, server.user)
+	assert.Equal(t, 1, len(server.connections), "handshake runs on one connection")
+}
+
+func TestLoadAWSRolesWithNTLMDisablesHTTP2(t *testing.T) {
+
+	server := newUnstartedNTLMServer(t, "SEA", "dicktracy", "test-password")
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	authenticator := Authenticator{AdfsHost: server.URL, User: `SEA\dicktracy`, Password: "test-password", NTLM: true,
+		Transport: TransportOptions{InsecureSkipVerify: true}}
+	_, err := authenticator.LoadAWSRoles()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"HTTP/1.1": true}, server.protocols)
+	assert.Equal(t, 1, len(server.connections), "handshake runs on one connection")
+}
+
+func TestAuthenticatorTracesNTLMLogin(t *testing.T) {
+
+	server := newNTLMServer(t, "SEA", "dicktracy", "test-password")
+	defer server.Close()
+
+	trace := Trace{Path: filepath.Join(tempDir(t), "login.har")}
+	_, err := Authenticator{AdfsHost: server.URL, User: `SEA\dicktracy`, Password: "test-password", NTLM: true, Trace: &trace}.LoadAWSRoles()
+	require.NoError(t, err)
+
+	b, err := ioutil.ReadFile(trace.Path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), server.URL+"/adfs/ls/wia")
+	assert.Contains(t, string(b), "NTLM REDACTED")
+}
+
+func TestLoadAWSRolesWithNTLMWrongPassword(t *testing.T) {
+
+	server := newNTLMServer(t, "SEA", "dicktracy", "test-password")
+	defer server.Close()
+
+	_, err := LoadAWSRolesWithNTLM(server.URL, SignOn{}, `SEA\dicktracy`, "wrong-password", newHttpClient())
+	assert.EqualError(t, err, "windows integrated authentication was rejected by adfs")
+	assert.Equal(t, "", server.user)
+}
+
+func TestKerberosFallsBackToNTLM(t *testing.T) {
+
+	// kerberos is not used, server does not offer Negotiate
+	server := newNTLMServer(t, "SEA", "dicktracy", "test-password")
+	defer server.Close()
+
+	kerberos := &Kerberos{CCache: newTestKDC(t).writeCCache(t, "dicktracy", "HTTP/127.0.0.1")}
+	_, err := Authenticator{AdfsHost: server.URL, User: `SEA\dicktracy`, Password: "test-password", Kerberos: kerberos, NTLM: true}.LoadAWSRoles()
+	require.NoError(t, err)
+	assert.Equal(t, `SEA\dicktracy`, server.user)
+}
+
+func TestParseNTLMUser(t *testing.T) {
+
+	domain, user, err := parseNTLMUser(`SEA\dicktracy`)
+	require.NoError(t, err)
+	assert.Equal(t, "SEA", domain)
+	assert.Equal(t, "dicktracy", user)
+
+	domain, user, err = parseNTLMUser("dicktracy@sea.test.com")
+	require.NoError(t, err)
+	assert.Equal(t, "", domain)
+	assert.Equal(t, "dicktracy@sea.test.com", user)
+
+	for _, invalid := range []string{"dicktracy", `SEA\`, `\dicktracy`} {
+		_, _, err = parseNTLMUser(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+type ntlmServer struct {
+	*httptest.Server
+	user        string
+	connections map[string]bool
+	protocols   map[string]bool
+}
+
+// adfs stand-in that offers only NTLM on windows integrated authentication endpoint, NTLMv2 response is verified
+// with the password of the user
+func newNTLMServer(t *testing.T, domain, user, password string) *ntlmServer {
+
+	server := newUnstartedNTLMServer(t, domain, user, password)
+	server.Start()
+	return server
+}
+
+func newUnstartedNTLMServer(t *testing.T, domain, user, password string) *ntlmServer {
+
+	server := &ntlmServer{connections: make(map[string]bool), protocols: make(map[string]bool)}
+	serverChallenge := []byte("chalenge")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/adfs/ls/idpinitiatedsignon.aspx", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/adfs/ls/wia", http.StatusFound)
+	})
+	mux.HandleFunc("/adfs/ls/wia", func(w http.ResponseWriter, r *http.Request) {
+		token, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "NTLM "))
+		if err != nil || len(token) < 12 || !bytes.HasPrefix(token, []byte("NTLMSSP\x00")) {
+			w.Header().Set("WWW-Authenticate", "NTLM")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		server.connections[r.RemoteAddr] = true
+		server.protocols[r.Proto] = true
+
+		switch binary.LittleEndian.Uint32(token[8:]) {
+		case 1:
+			w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(ntlmChallengeMessage(serverChallenge)))
+			w.WriteHeader(http.StatusUnauthorized)
+		case 3:
+			ntResponse := ntlmField(token, 20)
+			tokenDomain, tokenUser := ntlmString(ntlmField(token, 28)), ntlmString(ntlmField(token, 36))
+			expected := ntlmv2Proof(password, tokenUser, tokenDomain, serverChallenge, ntResponse[16:])
+			if tokenUser != user || tokenDomain != domain || !hmac.Equal(expected, ntResponse[:16]) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			server.user = tokenDomain + `\` + tokenUser
+			fmt.Fprintf(w, `<html><body><form method="POST" action="/saml">
+<input type="hidden" name="SAMLResponse" value="%s" /></form></body></html>`, testSamlResponse)
+		}
+	})
+	mux.HandleFunc("/saml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><form><fieldset>
+<div class="saml-account"><div class="saml-account-name">Account: lab (123456789)</div></div>
+</fieldset></form></body></html>`))
+	})
+	server.Server = httptest.NewUnstartedServer(mux)
+	return server
+}
+
+// challenge message with unicode, NTLM, extended session security and target info flags, target info is empty
+func ntlmChallengeMessage(serverChallenge []byte) []byte {
+
+	var b bytes.Buffer
+	b.WriteString("NTLMSSP\x00")
+	binary.Write(&b, binary.LittleEndian, uint32(2))
+	binary.Write(&b, binary.LittleEndian, [4]uint16{0, 0, 48, 0})
+	binary.Write(&b, binary.LittleEndian, uint32(0x00000001|0x00000200|0x00080000|0x00800000))
+	b.Write(serverChallenge)
+	b.Write(make([]byte, 8))
+	binary.Write(&b, binary.LittleEndian, [4]uint16{4, 4, 48, 0})
+	b.Write([]byte{0, 0, 0, 0})
+	return b.Bytes()
+}
+
+// returns payload of authenticate message field whose length and offset are at position
+func ntlmField(message []byte, position int) []byte {
+
+	length := int(binary.LittleEndian.Uint16(message[position:]))
+	offset := int(binary.LittleEndian.Uint32(message[position+4:]))
+	return message[offset : offset+length]
+}
+
+func ntlmString(b []byte) string {
+
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func ntlmUnicode(s string) []byte {
+
+	var b bytes.Buffer
+	for _, u := range utf16.Encode([]rune(s)) {
+		binary.Write(&b, binary.LittleEndian, u)
+	}
+	return b.Bytes()
+}
+
+func ntlmv2Proof(password, user, domain string, serverChallenge, blob []byte) []byte {
+
+	hash := md4.New()
+	hash.Write(ntlmUnicode(password))
+	mac := hmac.New(md5.New, hash.Sum(nil))
+	mac.Write(ntlmUnicode(strings.ToUpper(user) + domain))
+	mac = hmac.New(md5.New, mac.Sum(nil))
+	mac.Write(serverChallenge)
+	mac.Write(blob)
+	return mac.Sum(nil)
+}
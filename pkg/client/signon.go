@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Relying party identifiers of aws partitions
+const (
+	RelyingPartyAWS      = "urn:amazon:webservices"
+	RelyingPartyGovCloud = "urn:amazon:webservices:govcloud"
+	RelyingPartyChina    = "urn:amazon:webservices:cn-north-1"
+)
+
+const (
+	DefaultSignOnPath = "/adfs/ls/"
+	signOnPage        = "idpinitiatedsignon.aspx"
+)
+
+// relying party presets accepted by SignOn.RelyingParty besides full identifiers
+var relyingPartyPresets = map[string]string{
+	"aws":        RelyingPartyAWS,
+	"govcloud":   RelyingPartyGovCloud,
+	"aws-us-gov": RelyingPartyGovCloud,
+	"china":      RelyingPartyChina,
+	"aws-cn":     RelyingPartyChina,
+}
+
+// IdP-initiated sign-on endpoint of ADFS, zero value is '<host>/adfs/ls/idpinitiatedsignon.aspx?loginToRp=urn:amazon:webservices'
+type SignOn struct {
+	// full login url used as is, overrides path and relying party
+	URL string
+	// path of the sign-on endpoint e.g. '/saml/ls/', idpinitiatedsignon.aspx is appended to path ending with slash,
+	// defaults to '/adfs/ls/'
+	Path string
+	// relying party identifier, or preset: aws, govcloud (aws-us-gov) or china (aws-cn), defaults to aws
+	RelyingParty string
+}
+
+// Returns login url of the sign-on endpoint on adfs host e.g. 'https://sso.example.com'
+func (s SignOn) LoginURL(adfsHost string) (string, error) {
+
+	if s.URL != "" {
+		if _, err := url.Parse(s.URL); err != nil {
+			return "", fmt.Errorf("login url: %v", err)
+		}
+		return s.URL, nil
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(adfsHost, "/"))
+	if err != nil {
+		return "", fmt.Errorf("adfs host: %v", err)
+	}
+
+	path := s.Path
+	if path == "" {
+		path = DefaultSignOnPath
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if strings.HasSuffix(path, "/") {
+		path += signOnPage
+	}
+	u.Path += path
+
+	relyingParty := s.RelyingParty
+	if relyingParty == "" {
+		relyingParty = RelyingPartyAWS
+	}
+	if preset, ok := relyingPartyPresets[strings.ToLower(relyingParty)]; ok {
+		relyingParty = preset
+	}
+	// relying party identifier is usually urn, ':' is kept unescaped as ADFS expects it
+	u.RawQuery = "loginToRp=" + strings.ReplaceAll(url.QueryEscape(relyingParty), "%3A", ":")
+	return u.String(), nil
+}
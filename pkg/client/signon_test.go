@@ -0,0 +1,58 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignOnLoginURL(t *testing.T) {
+
+	for expected, signOn := range map[string]SignOn{
+		"https://sso.test.com/adfs/ls/idpinitiatedsignon.aspx?loginToRp=urn:amazon:webservices":            {},
+		"https://sso.test.com/adfs/ls/idpinitiatedsignon.aspx?loginToRp=urn:amazon:webservices:govcloud":   {RelyingParty: "govcloud"},
+		"https://sso.test.com/adfs/ls/idpinitiatedsignon.aspx?loginToRp=urn:amazon:webservices:cn-north-1": {RelyingParty: "aws-cn"},
+		"https://sso.test.com/saml/ls/idpinitiatedsignon.aspx?loginToRp=urn:custom:rp%2Faws":               {Path: "saml/ls/", RelyingParty: "urn:custom:rp/aws"},
+		"https://sso.test.com/adfs/ls/IdpInitiatedSignOn.aspx?loginToRp=urn:amazon:webservices":            {Path: "/adfs/ls/IdpInitiatedSignOn.aspx"},
+		"https://login.test.com/custom?rp=aws":                                                             {URL: "https://login.test.com/custom?rp=aws", Path: "/ignored/"},
+	} {
+		loginURL, err := signOn.LoginURL("https://sso.test.com/")
+		require.NoError(t, err)
+		assert.Equal(t, expected, loginURL)
+	}
+
+	_, err := SignOn{}.LoginURL("://sso.test.com")
+	assert.Error(t, err)
+}
+
+func TestLoadLoginFormFromSignOnPath(t *testing.T) {
+
+	var requestURI string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.RequestURI
+		w.Write([]byte(htmlWithMultipleForms))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	loginURL, err := SignOn{Path: "/saml/ls/", RelyingParty: "govcloud"}.LoginURL(server.URL)
+	require.NoError(t, err)
+	_, err = loadLoginForm(http.DefaultClient, loginURL, LoginForm{}, "test-user", "test-password", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/saml/ls/idpinitiatedsignon.aspx?loginToRp=urn:amazon:webservices:govcloud", requestURI)
+}
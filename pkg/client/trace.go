@@ -0,0 +1,538 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/html"
+	stdhtml "html"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const redacted = "REDACTED"
+
+// Records requests and responses of the login to HAR (HTTP archive) file, to find which of the ADFS, Duo or SAML
+// steps failed. Passwords, SAML assertions, Duo signatures, cookies and session tokens are redacted
+type Trace struct {
+	// HAR file, rewritten after every request so it is complete when the login fails
+	Path string
+	// records secrets as they are, only for test accounts and never for sharing
+	Unredacted bool
+}
+
+// header values that are always secret
+var secretHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"www-authenticate":    true,
+	"x-csrf-token":        true,
+}
+
+// form fields, query parameters and json keys that are secret, names containing secretNameParts are secret as well
+var secretNames = map[string]bool{
+	"cookie":        true,
+	"context":       true,
+	"sid":           true,
+	"txid":          true,
+	"session":       true,
+	"code":          true,
+	"authorization": true,
+}
+
+var secretNameParts = []string{"pass", "token", "secret", "saml", "sig_request", "sig_response"}
+
+func isSecretName(name string) bool {
+
+	lower := strings.ToLower(name)
+	if secretNames[lower] {
+		return true
+	}
+	for _, part := range secretNameParts {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// wraps transport with trace, or returns it as it is if trace is not set. Secret fields and values are redacted in
+// addition to the default secret names, empty values are ignored
+func (t *Trace) transport(base http.RoundTripper, secretFields, secretValues []string) http.RoundTripper {
+
+	if t == nil || t.Path == "" {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// new recorder starts new file, every client records its own login
+	transport := &traceTransport{base: base, trace: *t, secretFields: make(map[string]bool)}
+	for _, name := range secretFields {
+		transport.secretFields[strings.ToLower(name)] = true
+	}
+	for _, v := range secretValues {
+		if v != "" {
+			transport.secretValues = append(transport.secretValues, v)
+		}
+	}
+	return transport
+}
+
+type traceTransport struct {
+	base         http.RoundTripper
+	trace        Trace
+	secretFields map[string]bool
+	secretValues []string
+	mu           sync.Mutex
+	har          har
+}
+
+func (t *traceTransport) isSecretName(name string) bool {
+	return isSecretName(name) || t.secretFields[strings.ToLower(name)]
+}
+
+func (t *traceTransport) hasSecretValue(v string) bool {
+
+	for _, secret := range t.secretValues {
+		if strings.Contains(v, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// replaces secret values in text, also url and html escaped
+func (t *traceTransport) redactSecretValues(text string) string {
+
+	if t.trace.Unredacted {
+		return text
+	}
+	for _, secret := range t.secretValues {
+		for _, s := range []string{secret, url.QueryEscape(secret), url.PathEscape(secret), stdhtml.EscapeString(secret)} {
+			text = strings.ReplaceAll(text, s, redacted)
+		}
+	}
+	return text
+}
+
+func (t *traceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+
+	// request is cloned, transports must not modify the request
+	var requestBody []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		b, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		requestBody = b
+		r = r.Clone(r.Context())
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		if traceErr := t.record(start, r, requestBody, nil, nil); traceErr != nil {
+			return nil, fmt.Errorf("%v, %v", err, traceErr)
+		}
+		return nil, err
+	}
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+	if err := t.record(start, r, requestBody, resp, responseBody); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *traceTransport) record(start time.Time, r *http.Request, requestBody []byte, resp *http.Response, responseBody []byte) error {
+
+	entry := harEntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		Time:            float64(time.Since(start)) / float64(time.Millisecond),
+		Request:         t.request(r, requestBody),
+		Response:        harResponse{Status: 0, Headers: []harNameValue{}, Cookies: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+		Cache:           struct{}{},
+	}
+	entry.Timings.Wait = entry.Time
+	if resp != nil {
+		entry.Response = t.response(resp, responseBody)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.har.Log.Version = "1.2"
+	t.har.Log.Creator = harNameVersion{Name: "aws-adfs-login", Version: "1"}
+	t.har.Log.Entries = append(t.har.Log.Entries, entry)
+
+	b, err := json.MarshalIndent(t.har, "", "  ")
+	if err != nil {
+		return fmt.Errorf("trace: %v", err)
+	}
+	if err := ioutil.WriteFile(t.trace.Path, b, 0600); err != nil {
+		return fmt.Errorf("trace: %v", err)
+	}
+	return nil
+}
+
+func (t *traceTransport) request(r *http.Request, body []byte) harRequest {
+
+	request := harRequest{
+		Method:      r.Method,
+		URL:         t.url(r.URL),
+		HTTPVersion: r.Proto,
+		Headers:     t.headers(r.Header),
+		QueryString: t.nameValues(r.URL.Query()),
+		Cookies:     []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	for _, c := range r.Cookies() {
+		request.Cookies = append(request.Cookies, harNameValue{Name: c.Name, Value: t.value(c.Value)})
+	}
+	if body != nil {
+		mimeType := r.Header.Get("Content-Type")
+		request.PostData = &harPostData{MimeType: mimeType, Text: t.body(mimeType, body)}
+	}
+	return request
+}
+
+func (t *traceTransport) response(resp *http.Response, body []byte) harResponse {
+
+	response := harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Headers:     t.headers(resp.Header),
+		Cookies:     []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	for _, c := range resp.Cookies() {
+		response.Cookies = append(response.Cookies, harNameValue{Name: c.Name, Value: t.value(c.Value)})
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		if u, err := url.Parse(location); err == nil {
+			response.RedirectURL = t.url(u)
+		}
+	}
+
+	mimeType := resp.Header.Get("Content-Type")
+	response.Content.MimeType = mimeType
+	if decoded, err := html.Decompress(body, resp.Header.Get("Content-Encoding")); err == nil {
+		body = decoded
+	}
+	response.Content.Size = len(body)
+	if utf8.Valid(body) {
+		response.Content.Text = t.body(mimeType, body)
+	} else if t.trace.Unredacted {
+		response.Content.Text = base64.StdEncoding.EncodeToString(body)
+		response.Content.Encoding = "base64"
+	}
+	return response
+}
+
+func (t *traceTransport) value(v string) string {
+	if t.trace.Unredacted || v == "" {
+		return v
+	}
+	return redacted
+}
+
+func (t *traceTransport) url(u *url.URL) string {
+
+	if t.trace.Unredacted || u.RawQuery == "" {
+		return t.redactSecretValues(u.String())
+	}
+	redactedUrl := *u
+	redactedUrl.RawQuery = t.values(u.Query()).Encode()
+	return t.redactSecretValues(redactedUrl.String())
+}
+
+func (t *traceTransport) values(values url.Values) url.Values {
+
+	redactedValues := make(url.Values)
+	for name, vs := range values {
+		for _, v := range vs {
+			if t.isSecretName(name) || t.hasSecretValue(v) {
+				v = t.value(v)
+			}
+			redactedValues.Add(name, v)
+		}
+	}
+	return redactedValues
+}
+
+func (t *traceTransport) nameValues(values url.Values) []harNameValue {
+
+	nameValues := []harNameValue{}
+	redactedValues := t.values(values)
+	for _, name := range sortedKeys(redactedValues) {
+		for _, v := range redactedValues[name] {
+			nameValues = append(nameValues, harNameValue{Name: name, Value: v})
+		}
+	}
+	return nameValues
+}
+
+func (t *traceTransport) headers(header http.Header) []harNameValue {
+
+	headers := []harNameValue{}
+	for _, name := range sortedKeys(header) {
+		for _, v := range header[name] {
+			switch lower := strings.ToLower(name); {
+			case secretHeaders[lower]:
+				v = t.redactScheme(v)
+			case lower == "cookie":
+				v = t.redactCookies(v, "; ", "; ")
+			case lower == "set-cookie":
+				// cookie value is redacted, attributes are kept
+				v = t.redactCookies(v, "; ", "")
+			case lower == "location":
+				if u, err := url.Parse(v); err == nil {
+					v = t.url(u)
+				}
+			}
+			headers = append(headers, harNameValue{Name: name, Value: t.redactSecretValues(v)})
+		}
+	}
+	return headers
+}
+
+func sortedKeys(m map[string][]string) []string {
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// keeps authentication scheme e.g. 'Negotiate REDACTED'
+func (t *traceTransport) redactScheme(v string) string {
+
+	if t.trace.Unredacted {
+		return v
+	}
+	if fields := strings.Fields(v); len(fields) > 1 {
+		return fields[0] + " " + redacted
+	}
+	return v
+}
+
+// redacts values of 'name=value' pairs, only the first pair when rest is empty (set-cookie attributes)
+func (t *traceTransport) redactCookies(v, sep, rest string) string {
+
+	if t.trace.Unredacted {
+		return v
+	}
+	pairs := strings.Split(v, sep)
+	for i, pair := range pairs {
+		if i > 0 && rest == "" {
+			break
+		}
+		if j := strings.Index(pair, "="); j >= 0 {
+			pairs[i] = pair[:j+1] + t.value(pair[j+1:])
+		}
+	}
+	return strings.Join(pairs, sep)
+}
+
+var (
+	htmlInputTag   = regexp.MustCompile(`(?is)<input\b[^>]*>`)
+	htmlInputName  = regexp.MustCompile(`(?is)\bname\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	htmlInputValue = regexp.MustCompile(`(?is)\bvalue\s*=\s*(?:"[^"]*"|'[^']*'|[^\s>]+)`)
+	htmlDataAttr   = regexp.MustCompile(`(?is)\b(data-[\w.:-]+)\s*=\s*(?:"[^"]*"|'[^']*'|[^\s>]+)`)
+	htmlScript     = regexp.MustCompile(`(?is)(<script\b[^>]*>)(.*?)(</script\s*>)`)
+	// 'key': 'value', key: "value" and key = 'value' of inline scripts, e.g. Duo.init({'sig_request': '...'})
+	scriptKeyValue = regexp.MustCompile(`([\w$-]+)["']?\s*[:=]\s*(?:"((?:[^"\\\n]|\\.)*)"|'((?:[^'\\\n]|\\.)*)')`)
+)
+
+// form and json bodies have secret fields redacted, html pages have values of secret inputs, data attributes and
+// inline script keys redacted, other bodies are left out
+func (t *traceTransport) body(mimeType string, body []byte) string {
+
+	if t.trace.Unredacted {
+		return string(body)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return redacted
+		}
+		return t.values(values).Encode()
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return redacted
+		}
+		b, _ := json.Marshal(t.redactJSON(v))
+		return string(b)
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return t.redactHTML(string(body))
+	case len(body) == 0:
+		return ""
+	}
+	return redacted
+}
+
+func (t *traceTransport) redactHTML(page string) string {
+
+	page = htmlInputTag.ReplaceAllStringFunc(page, func(tag string) string {
+		m := htmlInputName.FindStringSubmatch(tag)
+		if m == nil || !t.isSecretName(m[1]+m[2]+m[3]) {
+			return tag
+		}
+		return htmlInputValue.ReplaceAllString(tag, `value="`+redacted+`"`)
+	})
+	// data-sig-request is matched as sig_request
+	page = htmlDataAttr.ReplaceAllStringFunc(page, func(attr string) string {
+		name := htmlDataAttr.FindStringSubmatch(attr)[1]
+		if !t.isSecretName(strings.ReplaceAll(name, "-", "_")) {
+			return attr
+		}
+		return name + `="` + redacted + `"`
+	})
+	page = htmlScript.ReplaceAllStringFunc(page, func(script string) string {
+		m := htmlScript.FindStringSubmatch(script)
+		return m[1] + t.redactScript(m[2]) + m[3]
+	})
+	return t.redactSecretValues(page)
+}
+
+// redacts quoted values of secret keys, the quotes are kept
+func (t *traceTransport) redactScript(script string) string {
+
+	return scriptKeyValue.ReplaceAllStringFunc(script, func(pair string) string {
+		loc := scriptKeyValue.FindStringSubmatchIndex(pair)
+		if !t.isSecretName(pair[loc[2]:loc[3]]) {
+			return pair
+		}
+		// value is in double or single quotes
+		for _, group := range []int{2, 3} {
+			if start, end := loc[2*group], loc[2*group+1]; start >= 0 {
+				return pair[:start] + t.value(pair[start:end]) + pair[end:]
+			}
+		}
+		return pair
+	})
+}
+
+func (t *traceTransport) redactJSON(v interface{}) interface{} {
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			if s, ok := field.(string); ok && (t.isSecretName(k) || t.hasSecretValue(s)) {
+				value[k] = t.value(s)
+			} else {
+				value[k] = t.redactJSON(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = t.redactJSON(item)
+		}
+	}
+	return v
+}
+
+// HAR 1.2 format, http://www.softwareishard.com/blog/har-12-spec/
+type har struct {
+	Log struct {
+		Version string         `json:"version"`
+		Creator harNameVersion `json:"creator"`
+		Entries []harEntry     `json:"entries"`
+	} `json:"log"`
+}
+
+type harNameVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	} `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text,omitempty"`
+		Encoding string `json:"encoding,omitempty"`
+	} `json:"content"`
+	RedirectURL string `json:"redirectURL"`
+	HeadersSize int    `json:"headersSize"`
+	BodySize    int    `json:"bodySize"`
+}
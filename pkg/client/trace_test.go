@@ -0,0 +1,221 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// adfs stand-in with login form, successful login sets session cookie and returns saml assertion form
+func newFormLoginServer(t *testing.T) *httptest.Server {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/adfs/ls/idpinitiatedsignon.aspx", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(htmlAdfs2016))
+			return
+		}
+		if r.FormValue("UserName") != "dicktracy" || r.FormValue("Password") != "test-password" {
+			w.Write([]byte(htmlAdfs2016))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "MSISAuth", Value: "session-cookie", Path: "/adfs"})
+		fmt.Fprintf(w, `<html><body><form method="POST" action="/saml">
+<input type="hidden" name="SAMLResponse" value="%s" /></form></body></html>`, testSamlResponse)
+	})
+	mux.HandleFunc("/saml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><form><fieldset>
+<div class="saml-account"><div class="saml-account-name">Account: lab (123456789)</div></div>
+</fieldset></form></body></html>`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func traceLogin(t *testing.T, trace Trace) (string, har) {
+
+	server := newFormLoginServer(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "trace")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	trace.Path = filepath.Join(dir, "login.har")
+
+	roles, err := Authenticator{AdfsHost: server.URL, User: "dicktracy", Password: "test-password", Trace: &trace}.LoadAWSRoles()
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+
+	b, err := ioutil.ReadFile(trace.Path)
+	require.NoError(t, err)
+	var h har
+	require.NoError(t, json.Unmarshal(b, &h))
+	return string(b), h
+}
+
+func TestTraceRedactsSecrets(t *testing.T) {
+
+	content, h := traceLogin(t, Trace{})
+
+	assert.Equal(t, "1.2", h.Log.Version)
+	require.Len(t, h.Log.Entries, 3)
+	assert.Equal(t, "GET", h.Log.Entries[0].Request.Method)
+	assert.Equal(t, 200, h.Log.Entries[0].Response.Status)
+	assert.Contains(t, h.Log.Entries[0].Response.Content.Text, `name="UserName"`)
+
+	login := h.Log.Entries[1]
+	assert.Equal(t, "POST", login.Request.Method)
+	require.NotNil(t, login.Request.PostData)
+	assert.Equal(t, "AuthMethod=FormsAuthentication&Password=REDACTED&UserName=dicktracy", login.Request.PostData.Text)
+	assert.Contains(t, login.Response.Headers, harNameValue{Name: "Set-Cookie", Value: "MSISAuth=REDACTED; Path=/adfs"})
+	assert.Equal(t, []harNameValue{{Name: "MSISAuth", Value: "REDACTED"}}, login.Response.Cookies)
+	assert.Contains(t, login.Response.Content.Text, `name="SAMLResponse" value="REDACTED"`)
+
+	assert.Equal(t, "SAMLResponse=REDACTED", h.Log.Entries[2].Request.PostData.Text)
+	assert.Contains(t, h.Log.Entries[2].Response.Content.Text, "Account: lab")
+
+	for _, secret := range []string{"test-password", "session-cookie", testSamlResponse} {
+		assert.NotContains(t, content, secret)
+	}
+}
+
+func TestTraceRedactsPasswordOfCustomField(t *testing.T) {
+
+	password := "s3cr3t&pw"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/adfs/ls/idpinitiatedsignon.aspx", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`<html><body><form method="post"><input type="text" name="login" /><input type="text" name="pw" /></form></body></html>`))
+			return
+		}
+		// theme that passes the password on in the redirect
+		http.Redirect(w, r, "/adfs/ls/check?pw="+url.QueryEscape(r.FormValue("pw")), http.StatusFound)
+	})
+	mux.HandleFunc("/adfs/ls/check", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><body><script>var login = {entered: "%s"};</script><form method="POST" action="/saml">
+<input type="hidden" name="SAMLResponse" value="%s" /></form></body></html>`, r.FormValue("pw"), testSamlResponse)
+	})
+	mux.HandleFunc("/saml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><div class="saml-account"><div class="saml-account-name">Account: lab (123456789)</div></div></body></html>`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	trace := Trace{Path: filepath.Join(tempDir(t), "login.har")}
+	_, err := Authenticator{AdfsHost: server.URL, User: "dicktracy", Password: password, Trace: &trace,
+		LoginForm: LoginForm{Selector: "form", UserField: "login", PasswordField: "pw"}}.LoadAWSRoles()
+	require.NoError(t, err)
+
+	b, err := ioutil.ReadFile(trace.Path)
+	require.NoError(t, err)
+	for _, secret := range []string{password, url.QueryEscape(password), "s3cr3t"} {
+		assert.NotContains(t, string(b), secret)
+	}
+	var h har
+	require.NoError(t, json.Unmarshal(b, &h))
+	assert.Equal(t, "login=dicktracy&pw=REDACTED", h.Log.Entries[1].Request.PostData.Text)
+
+	transport := &traceTransport{secretValues: []string{password}}
+	assert.Equal(t, `{"hint":"REDACTED","user":"dicktracy"}`,
+		transport.body("application/json", []byte(`{"user": "dicktracy", "hint": "is s3cr3t&pw"}`)))
+}
+
+func TestTraceUnredacted(t *testing.T) {
+
+	content, _ := traceLogin(t, Trace{Unredacted: true})
+	for _, secret := range []string{"test-password", "session-cookie", testSamlResponse} {
+		assert.Contains(t, content, secret)
+	}
+}
+
+func TestTraceRedactsJSONAndQuery(t *testing.T) {
+
+	transport := &traceTransport{}
+	assert.Equal(t, `{"response":{"cookie":"REDACTED","status":"SUCCESS","txid":"REDACTED"},"stat":"OK"}`,
+		transport.body("application/json", []byte(`{"stat": "OK", "response": {"txid": "tx1", "status": "SUCCESS", "cookie": "AUTH|abc"}}`)))
+	assert.Equal(t, "REDACTED", transport.body("text/plain", []byte("password")))
+	assert.Equal(t, `<input name='duo_sig_request' value="REDACTED"><input name=duo_host value=api.duo.test>`,
+		transport.body("text/html; charset=utf-8", []byte(`<input name='duo_sig_request' value='TX|abc:APP|def'><input name=duo_host value=api.duo.test>`)))
+
+	assert.Equal(t, `<div data-sig-request="REDACTED" data-host='api.duo.test'>`,
+		transport.body("text/html", []byte(`<div data-sig-request='TX|abc:APP|def' data-host='api.duo.test'>`)))
+	assert.Equal(t, `<script>var c = {token: "REDACTED", host: 'api.duo.test'}; c.secret = 'REDACTED';</script>`,
+		transport.body("text/html", []byte(`<script>var c = {token: "t1", host: 'api.duo.test'}; c.secret = 'it\'s';</script>`)))
+
+	r, err := http.NewRequest(http.MethodGet, "https://sso.test.com/frame/status?sid=s1&v=2.6", nil)
+	require.NoError(t, err)
+	r.Header.Set("Authorization", "Negotiate YIIG")
+	r.Header.Set("Cookie", "a=1; b=2")
+	request := transport.request(r, nil)
+	assert.Equal(t, "https://sso.test.com/frame/status?sid=REDACTED&v=2.6", request.URL)
+	assert.Equal(t, []harNameValue{{Name: "sid", Value: "REDACTED"}, {Name: "v", Value: "2.6"}}, request.QueryString)
+	assert.Equal(t, []harNameValue{{Name: "Authorization", Value: "Negotiate REDACTED"}, {Name: "Cookie", Value: "a=REDACTED; b=REDACTED"}}, request.Headers)
+}
+
+func TestTraceRedactsDuoLoginPage(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(htmlAdfsDuoLogin))
+	}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	trace := Trace{Path: filepath.Join(dir, "login.har")}
+	resp, err := (&http.Client{Transport: trace.transport(nil, nil, nil)}).Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	b, err := ioutil.ReadFile(trace.Path)
+	require.NoError(t, err)
+	var h har
+	require.NoError(t, json.Unmarshal(b, &h))
+	require.Len(t, h.Log.Entries, 1)
+	content := h.Log.Entries[0].Response.Content.Text
+	assert.NotContains(t, content, "TX|ZGVj")
+	assert.NotContains(t, content, "APP|ZGVj", "sig_response is built from APP part of sig_request")
+	assert.Contains(t, content, `Duo.init( {'duoHost': 'api-f4ec.duosecurity.com', 'sig_request': 'REDACTED' } );`)
+	assert.Contains(t, content, `name='duo_host' id='duo_host' value='api-f4ec.duosecurity.com'`)
+}
+
+// duo step of ADFS login, sig_request is in hidden input and in inline script
+var htmlAdfsDuoLogin = `<html lang="en-US">
+<body dir="ltr" class="body">
+    <div id="authArea" class="groupMargin">
+        <div class='groupMargin'>
+            <input type='hidden' name='duo_host' id='duo_host' value='api-f4ec.duosecurity.com' />
+            <input type='hidden' name='duo_sig_request' id='duo_sig_request' value='TX|ZGVjYWZccHJlaXNpbmdlcnxE|f68c38f2d38662f3dad7d916a257b:APP|ZGVjYWZccHJlaXNpbmdlcnxESVBFSV|7be95a3671b67964fe1' />
+            <form method='POST' id='duo_form'>
+                <input id='context' type='hidden' name='Context' value='encrypted data'/>
+                <input id='authMethod' type='hidden' name='AuthMethod' value='DuoAdfsAdapter' />
+            </form>
+            <script>
+
+                Duo.init( {'duoHost': 'api-f4ec.duosecurity.com', 'sig_request': 'TX|ZGVjYWZccHJlaXNpbmdlcnxE|f68c38f2d38662f3dad7d916a257b:APP|ZGVjYWZccHJlaXNpbmdlcnxESVBFSV|7be95a3671b67964fe1' } );
+            </script>
+            <iframe id="duo_iframe" frameborder="0"></iframe>
+        </div>
+    </div>
+</body>
+</html>
+`
@@ -0,0 +1,256 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"software.sslmate.com/src/go-pkcs12"
+	"testing"
+	"time"
+)
+
+// self-signed client certificate and its key
+type testClientCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestClientCert(t *testing.T) testClientCert {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dicktracy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return testClientCert{cert: cert, key: key}
+}
+
+// writes certificate and key PEM files, returns their paths
+func (c testClientCert) writePEM(t *testing.T, dir string) (certPath, keyPath string) {
+
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	certPath, keyPath = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	require.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certPath, keyPath
+}
+
+// writes PKCS#12 file with certificate and key, returns its path
+func (c testClientCert) writePKCS12(t *testing.T, dir, password string) string {
+
+	pfx, err := pkcs12.Modern.Encode(c.key, c.cert, nil, password)
+	require.NoError(t, err)
+	path := filepath.Join(dir, "client.p12")
+	require.NoError(t, ioutil.WriteFile(path, pfx, 0600))
+	return path
+}
+
+// tls server that requires client certificate signed by (or equal to) the given one
+func newClientCertServer(t *testing.T, clientCert *x509.Certificate, handler http.Handler) *httptest.Server {
+
+	server := httptest.NewUnstartedServer(handler)
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	return server
+}
+
+func writeCABundle(t *testing.T, dir string, server *httptest.Server) string {
+
+	path := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	return path
+}
+
+func tempDir(t *testing.T) string {
+
+	dir, err := ioutil.TempDir("", "transport")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestTransportCABundleAndClientCert(t *testing.T) {
+
+	clientCert := newTestClientCert(t)
+	server := newClientCertServer(t, clientCert.cert, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	caBundle := writeCABundle(t, dir, server)
+	certPath, keyPath := clientCert.writePEM(t, dir)
+
+	get := func(options TransportOptions) (string, error) {
+		c, err := NewClient(ClientOptions{Timeout: 5 * time.Second, Transport: options})
+		if err != nil {
+			return "", err
+		}
+		resp, err := c.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	body, err := get(TransportOptions{CABundle: caBundle, ClientCert: certPath, ClientKey: keyPath})
+	require.NoError(t, err)
+	assert.Equal(t, "dicktracy", body)
+
+	body, err = get(TransportOptions{InsecureSkipVerify: true, ClientCert: certPath, ClientKey: keyPath})
+	require.NoError(t, err)
+	assert.Equal(t, "dicktracy", body)
+
+	_, err = get(TransportOptions{ClientCert: certPath, ClientKey: keyPath})
+	assert.Error(t, err, "server certificate is not trusted without ca bundle")
+	_, err = get(TransportOptions{CABundle: caBundle})
+	assert.Error(t, err, "server requires client certificate")
+
+	_, err = get(TransportOptions{ClientKey: keyPath})
+	assert.EqualError(t, err, "client certificate: client key is set without certificate")
+	_, err = get(TransportOptions{CABundle: keyPath})
+	assert.EqualError(t, err, "ca bundle "+keyPath+" does not contain PEM certificates")
+}
+
+func TestTransportClientCertFormats(t *testing.T) {
+
+	clientCert := newTestClientCert(t)
+	server := newClientCertServer(t, clientCert.cert, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir := tempDir(t)
+	certPath, keyPath := clientCert.writePEM(t, dir)
+	combinedPath := filepath.Join(dir, "combined.pem")
+	certPEM, err := ioutil.ReadFile(certPath)
+	require.NoError(t, err)
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(combinedPath, append(certPEM, keyPEM...), 0600))
+	p12Path := clientCert.writePKCS12(t, dir, "p12-password")
+
+	for name, options := range map[string]TransportOptions{
+		"pem":      {ClientCert: certPath, ClientKey: keyPath},
+		"combined": {ClientCert: combinedPath},
+		"pkcs12":   {ClientCert: p12Path, ClientCertPassword: "p12-password"},
+	} {
+		options.InsecureSkipVerify = true
+		c, err := NewClient(ClientOptions{Transport: options})
+		require.NoError(t, err, name)
+		resp, err := c.Get(server.URL)
+		require.NoError(t, err, name)
+		resp.Body.Close()
+	}
+
+	_, err = NewClient(ClientOptions{Transport: TransportOptions{ClientCert: p12Path, ClientCertPassword: "wrong"}})
+	assert.Error(t, err)
+}
+
+func TestTransportTLSMinVersion(t *testing.T) {
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	caBundle := writeCABundle(t, tempDir(t), server)
+	for version, ok := range map[string]bool{"": true, "1.2": true, "1.3": false} {
+		tlsVersion, err := ParseTLSVersion(version)
+		require.NoError(t, err)
+		c, err := NewClient(ClientOptions{Transport: TransportOptions{CABundle: caBundle, TLSMinVersion: tlsVersion}})
+		require.NoError(t, err)
+		resp, err := c.Get(server.URL)
+		if ok {
+			require.NoError(t, err, version)
+			resp.Body.Close()
+		} else {
+			assert.Error(t, err, version)
+		}
+	}
+
+	_, err := ParseTLSVersion("1.4")
+	assert.Error(t, err)
+}
+
+func TestTransportProxy(t *testing.T) {
+
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String()+" "+r.Header.Get("Proxy-Authorization"))
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	proxyURL := "http://proxyuser:proxypass@" + proxy.Listener.Addr().String()
+	c, err := NewClient(ClientOptions{Transport: TransportOptions{Proxy: proxyURL, NoProxy: ".example.com"}})
+	require.NoError(t, err)
+
+	resp, err := c.Get("http://sso.corp.test/adfs/ls/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	// basic auth of proxyuser:proxypass
+	assert.Equal(t, []string{"http://sso.corp.test/adfs/ls/ Basic cHJveHl1c2VyOnByb3h5cGFzcw=="}, proxied)
+
+	transport := c.Transport.(*http.Transport)
+	for target, expected := range map[string]string{
+		"https://sso.corp.test/adfs/ls/": proxyURL,
+		"https://sso.example.com/":       "",
+		"http://127.0.0.1:8080/":         "",
+	} {
+		r, err := http.NewRequest(http.MethodGet, target, nil)
+		require.NoError(t, err)
+		u, err := transport.Proxy(r)
+		require.NoError(t, err)
+		if expected == "" {
+			assert.Nil(t, u, target)
+		} else {
+			assert.Equal(t, expected, u.String(), target)
+		}
+	}
+}
+
+func TestRedactProxyURL(t *testing.T) {
+	assert.Equal(t, "REDACTED@proxy:8080", redactProxyURL("http://user:p@ss@proxy:8080"))
+	assert.Equal(t, "http://proxy:8080", redactProxyURL("http://proxy:8080"))
+}
@@ -0,0 +1,133 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ADFS redirects to windows integrated authentication (/adfs/ls/wia) only user agents listed in WIASupportedUserAgents,
+// internet explorer 11 is in the default list
+const wiaUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; Trident/7.0; rv:11.0) like Gecko"
+
+// wraps transport with windows integrated authentication that answers challenges of the adfs host
+type wiaAuth func(base http.RoundTripper, host string) (http.RoundTripper, error)
+
+// returns copy of the client that authenticates to the adfs host, and sign-on request. Authentications are
+// chained, challenge that the first one does not answer (e.g. NTLM only) is passed to the next one
+func newWIALogin(adfsHost string, signOn SignOn, c *http.Client, auths ...wiaAuth) (*http.Client, wiaRequest, error) {
+
+	loginUrl, err := signOn.LoginURL(adfsHost)
+	if err != nil {
+		return nil, wiaRequest{}, err
+	}
+	u, err := url.Parse(loginUrl)
+	if err != nil {
+		return nil, wiaRequest{}, fmt.Errorf("login url: %v", err)
+	}
+
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for _, auth := range auths {
+		if transport, err = auth(transport, u.Hostname()); err != nil {
+			return nil, wiaRequest{}, err
+		}
+	}
+	wiaClient := *c
+	wiaClient.Transport = transport
+	return &wiaClient, wiaRequest{loginUrl: loginUrl}, nil
+}
+
+// sign-on request of windows integrated authentication, response contains saml assertion form (or duo form)
+type wiaRequest struct {
+	loginUrl string
+}
+
+func (r wiaRequest) Submit(c *http.Client) (*http.Response, error) {
+
+	req, err := http.NewRequest(http.MethodGet, r.loginUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", wiaUserAgent)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, errors.New("windows integrated authentication was rejected by adfs")
+	}
+	return resp, nil
+}
+
+// returns decoded token of 'WWW-Authenticate: <scheme> <token>' challenge, empty token if scheme is offered
+// without one, or nil if the scheme is not offered
+func challenge(resp *http.Response, scheme string) []byte {
+
+	for _, v := range resp.Header.Values("WWW-Authenticate") {
+		fields := strings.Fields(v)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], scheme) {
+			continue
+		}
+		if len(fields) == 1 {
+			return []byte{}
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return []byte{}
+		}
+		return token
+	}
+	return nil
+}
+
+// credentials are sent only to the adfs host, for requests that have not been authenticated by other scheme
+// and can be repeated
+func canAuthenticate(r *http.Request, host string) bool {
+
+	if r.URL.Hostname() != host || r.Header.Get("Authorization") != "" {
+		return false
+	}
+	// request body has been sent already and can be repeated only if it can be recreated
+	return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
+
+func cloneRequest(r *http.Request) (*http.Request, error) {
+
+	clone := r.Clone(r.Context())
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
+
+// reads response body to the end, so the connection is reused for the rest of connection-based handshake
+func discard(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
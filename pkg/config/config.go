@@ -0,0 +1,319 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config loads named login profiles (ADFS host, user, MFA, role, ...) from YAML or TOML file,
+// so a team can share checked-in configuration
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// path of the config file, overrides default path
+	PathEnvVar = "AWS_ADFS_CONFIG"
+	// profile used when no profile is requested, overrides default profile set in the config file
+	ProfileEnvVar = "AWS_ADFS_PROFILE"
+)
+
+type Config struct {
+	// profile used when no profile is requested
+	Default  string             `yaml:"default" toml:"default"`
+	Profiles map[string]Profile `yaml:"profiles" toml:"profiles"`
+}
+
+// Login profile, every field can be overridden by AWS_ADFS_<FIELD> environment variable e.g. AWS_ADFS_USER
+type Profile struct {
+	// name of the profile that this profile inherits unset fields from
+	Inherits string `yaml:"inherits" toml:"inherits"`
+	AdfsHost string `yaml:"adfs_host" toml:"adfs_host"`
+	// sign-on endpoint, see client.SignOn: full login url, or path and relying party identifier (or preset)
+	LoginURL     string `yaml:"login_url" toml:"login_url"`
+	SignOnPath   string `yaml:"sign_on_path" toml:"sign_on_path"`
+	RelyingParty string `yaml:"relying_party" toml:"relying_party"`
+	// login form css selector and user and password input names for customised ADFS themes, see client.LoginForm
+	LoginForm     string `yaml:"login_form" toml:"login_form"`
+	UserField     string `yaml:"user_field" toml:"user_field"`
+	PasswordField string `yaml:"password_field" toml:"password_field"`
+	User          string `yaml:"user" toml:"user"`
+	// 'form' (user and password, default), or windows integrated authentication: 'kerberos', 'ntlm' (user and
+	// password) or 'kerberos,ntlm'. Kerberos uses credentials cache from KRB5CCNAME, or keytab and its principal
+	// e.g. user@EXAMPLE.COM
+	Auth              string `yaml:"auth" toml:"auth"`
+	Keytab            string `yaml:"keytab" toml:"keytab"`
+	KerberosPrincipal string `yaml:"kerberos_principal" toml:"kerberos_principal"`
+	MFADevice         string `yaml:"mfa_device" toml:"mfa_device"`
+	MFAFactor         string `yaml:"mfa_factor" toml:"mfa_factor"`
+	// role is selected either by arn, or by account (name or id) and role name
+	RoleArn  string   `yaml:"role_arn" toml:"role_arn"`
+	Account  string   `yaml:"account" toml:"account"`
+	Role     string   `yaml:"role" toml:"role"`
+	Duration Duration `yaml:"duration" toml:"duration"`
+	// aws profile that credentials are written to
+	OutputProfile string   `yaml:"output_profile" toml:"output_profile"`
+	STSRegion     string   `yaml:"sts_region" toml:"sts_region"`
+	Timeout       Duration `yaml:"timeout" toml:"timeout"`
+	// HAR file the login is recorded to for debugging, secrets are redacted
+	Trace string `yaml:"trace" toml:"trace"`
+	// proxy and TLS settings of ADFS and Duo requests, see client.TransportOptions
+	CABundle      string `yaml:"ca_bundle" toml:"ca_bundle"`
+	ClientCert    string `yaml:"client_cert" toml:"client_cert"`
+	ClientKey     string `yaml:"client_key" toml:"client_key"`
+	Proxy         string `yaml:"proxy" toml:"proxy"`
+	NoProxy       string `yaml:"no_proxy" toml:"no_proxy"`
+	TLSMinVersion string `yaml:"tls_min_version" toml:"tls_min_version"`
+	// only for lab environments with self-signed certificates, pointer so that child profile can set false
+	InsecureSkipVerify *bool `yaml:"insecure_skip_verify" toml:"insecure_skip_verify"`
+	// attempts of login page, duo status and sts requests failing with transient errors, and delay before the first
+	// retry, see retry.Policy. 0 or 1 does not retry
+	RetryAttempts int      `yaml:"retry_attempts" toml:"retry_attempts"`
+	RetryBackoff  Duration `yaml:"retry_backoff" toml:"retry_backoff"`
+}
+
+// Duration in time.ParseDuration format e.g. '1h30m'
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Returns path from AWS_ADFS_CONFIG environment variable, or '.aws-adfs-login.yaml' in home directory
+func DefaultPath() string {
+
+	if path := os.Getenv(PathEnvVar); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".aws-adfs-login.yaml"
+	}
+	return filepath.Join(home, ".aws-adfs-login.yaml")
+}
+
+// Loads config file, format is selected by extension: .toml or .yaml/.yml
+func Load(path string) (Config, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("load config: %v", err)
+	}
+
+	var config Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(b, &config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &config)
+	default:
+		return Config{}, fmt.Errorf("load config %s: unknown format, expected .yaml, .yml or .toml extension", path)
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("load config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Returns profile with inherited fields and environment overrides applied. Empty name selects profile from
+// AWS_ADFS_PROFILE environment variable or default profile of the config
+func (c Config) Profile(name string) (Profile, error) {
+
+	if name == "" {
+		name = os.Getenv(ProfileEnvVar)
+	}
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		return Profile{}, errors.New("no profile selected and config does not set default profile")
+	}
+
+	profile, err := c.inheritedProfile(name, nil)
+	if err != nil {
+		return Profile{}, err
+	}
+	if err := profile.applyEnv(); err != nil {
+		return Profile{}, fmt.Errorf("profile %s: %v", name, err)
+	}
+	profile.Inherits = ""
+	return profile, nil
+}
+
+// Returns sorted profile names
+func (c Config) ProfileNames() []string {
+
+	var names []string
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c Config) inheritedProfile(name string, visited []string) (Profile, error) {
+
+	for _, v := range visited {
+		if v == name {
+			return Profile{}, fmt.Errorf("profile inheritance cycle: %s -> %s", strings.Join(visited, " -> "), name)
+		}
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %s does not exist", name)
+	}
+	if profile.Inherits == "" {
+		return profile, nil
+	}
+
+	parent, err := c.inheritedProfile(profile.Inherits, append(visited, name))
+	if err != nil {
+		return Profile{}, err
+	}
+	return profile.merge(parent), nil
+}
+
+// returns profile with unset fields taken from parent
+func (p Profile) merge(parent Profile) Profile {
+
+	parentFields := parent.fields()
+	for i, f := range p.fields() {
+		if *f.value == "" {
+			*f.value = *parentFields[i].value
+		}
+	}
+	if p.RoleArn == "" && p.Account == "" && p.Role == "" {
+		p.RoleArn, p.Account, p.Role = parent.RoleArn, parent.Account, parent.Role
+	}
+	if p.Duration == 0 {
+		p.Duration = parent.Duration
+	}
+	if p.Timeout == 0 {
+		p.Timeout = parent.Timeout
+	}
+	if p.InsecureSkipVerify == nil {
+		p.InsecureSkipVerify = parent.InsecureSkipVerify
+	}
+	if p.RetryAttempts == 0 {
+		p.RetryAttempts = parent.RetryAttempts
+	}
+	if p.RetryBackoff == 0 {
+		p.RetryBackoff = parent.RetryBackoff
+	}
+	return p
+}
+
+func (p *Profile) applyEnv() error {
+
+	// role set in environment replaces role inherited from the file, it is either arn or account and role name
+	if _, ok := os.LookupEnv("AWS_ADFS_ROLE_ARN"); ok {
+		p.Account, p.Role = "", ""
+	} else if os.Getenv("AWS_ADFS_ACCOUNT") != "" || os.Getenv("AWS_ADFS_ROLE") != "" {
+		p.RoleArn = ""
+	}
+	for _, f := range append(p.fields(), p.roleFields()...) {
+		if v, ok := os.LookupEnv(f.envVar()); ok {
+			*f.value = v
+		}
+	}
+	for _, f := range []struct {
+		name  string
+		value *Duration
+	}{{"duration", &p.Duration}, {"timeout", &p.Timeout}, {"retry_backoff", &p.RetryBackoff}} {
+		envVar := "AWS_ADFS_" + strings.ToUpper(f.name)
+		if v, ok := os.LookupEnv(envVar); ok {
+			if err := f.value.UnmarshalText([]byte(v)); err != nil {
+				return fmt.Errorf("%s: %v", envVar, err)
+			}
+		}
+	}
+	if v, ok := os.LookupEnv("AWS_ADFS_INSECURE_SKIP_VERIFY"); ok {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("AWS_ADFS_INSECURE_SKIP_VERIFY: %v", err)
+		}
+		p.InsecureSkipVerify = &insecure
+	}
+	if v, ok := os.LookupEnv("AWS_ADFS_RETRY_ATTEMPTS"); ok {
+		attempts, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("AWS_ADFS_RETRY_ATTEMPTS: %v", err)
+		}
+		p.RetryAttempts = attempts
+	}
+	return nil
+}
+
+type profileField struct {
+	name  string
+	value *string
+}
+
+func (f profileField) envVar() string {
+	return "AWS_ADFS_" + strings.ToUpper(f.name)
+}
+
+// string fields inherited and overridden field by field, role fields are inherited together
+func (p *Profile) fields() []profileField {
+	return []profileField{
+		{"adfs_host", &p.AdfsHost},
+		{"login_url", &p.LoginURL},
+		{"sign_on_path", &p.SignOnPath},
+		{"relying_party", &p.RelyingParty},
+		{"login_form", &p.LoginForm},
+		{"user_field", &p.UserField},
+		{"password_field", &p.PasswordField},
+		{"user", &p.User},
+		{"auth", &p.Auth},
+		{"keytab", &p.Keytab},
+		{"kerberos_principal", &p.KerberosPrincipal},
+		{"mfa_device", &p.MFADevice},
+		{"mfa_factor", &p.MFAFactor},
+		{"output_profile", &p.OutputProfile},
+		{"sts_region", &p.STSRegion},
+		{"trace", &p.Trace},
+		{"ca_bundle", &p.CABundle},
+		{"client_cert", &p.ClientCert},
+		{"client_key", &p.ClientKey},
+		{"proxy", &p.Proxy},
+		{"no_proxy", &p.NoProxy},
+		{"tls_min_version", &p.TLSMinVersion},
+	}
+}
+
+func (p *Profile) roleFields() []profileField {
+	return []profileField{
+		{"role_arn", &p.RoleArn},
+		{"account", &p.Account},
+		{"role", &p.Role},
+	}
+}
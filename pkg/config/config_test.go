@@ -0,0 +1,330 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"crypto/tls"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/client"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func mustLoginURL(t *testing.T, profile Profile) string {
+
+	authenticator := profile.Authenticator("")
+	loginURL, err := authenticator.SignOn.LoginURL(authenticator.AdfsHost)
+	require.NoError(t, err)
+	return loginURL
+}
+
+func TestLoadYAMLAndTOML(t *testing.T) {
+
+	for name, content := range map[string]string{"config.yaml": yamlConfig, "config.toml": tomlConfig} {
+		config, err := Load(writeConfig(t, name, content))
+		require.NoError(t, err, name)
+
+		assert.Equal(t, []string{"base", "lab", "prod"}, config.ProfileNames(), name)
+
+		profile, err := config.Profile("")
+		require.NoError(t, err, name)
+		assert.Equal(t, Profile{
+			AdfsHost:      "https://sso.test.com",
+			User:          `SEA\dicktracy`,
+			MFADevice:     "phone1",
+			MFAFactor:     "Duo Push",
+			Account:       "eps-lab",
+			Role:          "ADFS-Developers",
+			Duration:      Duration(2 * time.Hour),
+			OutputProfile: "lab",
+			STSRegion:     "eu-west-1",
+			Timeout:       Duration(time.Minute),
+		}, profile, name)
+		require.NoError(t, profile.Validate())
+
+		profile, err = config.Profile("prod")
+		require.NoError(t, err, name)
+		assert.Equal(t, "arn:aws:iam::456:role/Admin", profile.RoleArn, name)
+		assert.Equal(t, "", profile.Role, "role is inherited together with account")
+		assert.Equal(t, Duration(time.Hour), profile.Duration, name)
+		assert.Equal(t, "govcloud", profile.RelyingParty, name)
+		assert.Equal(t, "https://sso.test.com/adfs/ls/idpinitiatedsignon.aspx?loginToRp=urn:amazon:webservices:govcloud",
+			mustLoginURL(t, profile), name)
+	}
+}
+
+func TestProfileEnvOverrides(t *testing.T) {
+
+	config, err := Load(writeConfig(t, "config.yml", yamlConfig))
+	require.NoError(t, err)
+
+	os.Setenv("AWS_ADFS_PROFILE", "prod")
+	os.Setenv("AWS_ADFS_USER", `SEA\other`)
+	os.Setenv("AWS_ADFS_ACCOUNT", "eps-prod")
+	os.Setenv("AWS_ADFS_ROLE", "User")
+	os.Setenv("AWS_ADFS_DURATION", "15m")
+	defer func() {
+		for _, v := range []string{"AWS_ADFS_PROFILE", "AWS_ADFS_USER", "AWS_ADFS_ACCOUNT", "AWS_ADFS_ROLE", "AWS_ADFS_DURATION"} {
+			os.Unsetenv(v)
+		}
+	}()
+
+	profile, err := config.Profile("")
+	require.NoError(t, err)
+	assert.Equal(t, "https://sso.test.com", profile.AdfsHost)
+	assert.Equal(t, `SEA\other`, profile.User)
+	assert.Equal(t, "", profile.RoleArn)
+	assert.Equal(t, "eps-prod", profile.Account)
+	assert.Equal(t, "User", profile.Role)
+	assert.Equal(t, Duration(15*time.Minute), profile.Duration)
+
+	os.Setenv("AWS_ADFS_DURATION", "forever")
+	_, err = config.Profile("")
+	assert.Error(t, err)
+}
+
+func TestProfileErrors(t *testing.T) {
+
+	config, err := Load(writeConfig(t, "config.yaml", `
+profiles:
+  a:
+    inherits: b
+  b:
+    inherits: a
+  c:
+    inherits: missing
+`))
+	require.NoError(t, err)
+
+	_, err = config.Profile("")
+	assert.EqualError(t, err, "no profile selected and config does not set default profile")
+	_, err = config.Profile("a")
+	assert.EqualError(t, err, "profile inheritance cycle: a -> b -> a")
+	_, err = config.Profile("c")
+	assert.EqualError(t, err, "profile missing does not exist")
+
+	_, err = Load(writeConfig(t, "config.json", "{}"))
+	assert.Error(t, err)
+}
+
+func TestSelectRole(t *testing.T) {
+
+	roles := aws.Roles{
+		{Account: aws.Account{Id: "123", Name: "eps-lab"}, Arn: "arn:aws:iam::123:role/ADFS-Developers", Name: "ADFS-Developers"},
+		{Account: aws.Account{Id: "456", Name: "eps-prod"}, Arn: "arn:aws:iam::456:role/Admin", Name: "Admin"},
+	}
+
+	role, err := Profile{Account: "eps-lab", Role: "ADFS-Developers"}.SelectRole(roles)
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123:role/ADFS-Developers", role.Arn)
+
+	role, err = Profile{Account: "456", Role: "Admin"}.SelectRole(roles)
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::456:role/Admin", role.Arn)
+
+	role, err = Profile{RoleArn: "arn:aws:iam::456:role/Admin"}.SelectRole(roles)
+	require.NoError(t, err)
+	assert.Equal(t, "Admin", role.Name)
+
+	_, err = Profile{Account: "eps-lab", Role: "Admin"}.SelectRole(roles)
+	assert.EqualError(t, err, `no role matches "eps-lab/Admin"`)
+
+	_, err = Profile{Account: "eps-*"}.SelectRole(roles)
+	assert.IsType(t, &aws.AmbiguousRoleError{}, err)
+	role, err = Profile{Role: "admin"}.SelectRole(roles)
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::456:role/Admin", role.Arn)
+}
+
+func TestValidate(t *testing.T) {
+
+	assert.EqualError(t, Profile{}.Validate(), "profile is missing adfs_host, user")
+	assert.Error(t, Profile{AdfsHost: "h", User: "u", RoleArn: "arn", Role: "Admin"}.Validate())
+	assert.NoError(t, Profile{AdfsHost: "h", User: "u", RoleArn: "arn"}.Validate())
+
+	assert.NoError(t, Profile{AdfsHost: "h", Auth: "kerberos"}.Validate(), "user is not needed with kerberos")
+	assert.EqualError(t, Profile{AdfsHost: "h", Auth: "kerberos", Keytab: "user.keytab"}.Validate(),
+		"profile sets keytab without kerberos_principal")
+	assert.EqualError(t, Profile{AdfsHost: "h", User: "u", Auth: "basic"}.Validate(), `profile auth "basic" is not form, kerberos, ntlm or certificate`)
+	assert.Error(t, Profile{AdfsHost: "h", User: "u", Auth: "form,ntlm"}.Validate())
+	assert.EqualError(t, Profile{AdfsHost: "h", Auth: "kerberos, ntlm"}.Validate(), "profile is missing user")
+	assert.NoError(t, Profile{AdfsHost: "h", User: "u", Auth: "kerberos, ntlm"}.Validate())
+	assert.EqualError(t, Profile{AdfsHost: "h", Auth: "certificate"}.Validate(), "profile auth certificate needs client_cert")
+	assert.NoError(t, Profile{AdfsHost: "h", Auth: "certificate", ClientCert: "user.p12"}.Validate(), "user is not needed with certificate")
+	assert.Error(t, Profile{AdfsHost: "h", Auth: "certificate,ntlm", ClientCert: "user.p12"}.Validate())
+	assert.EqualError(t, Profile{AdfsHost: "h", User: "u", ClientKey: "user-key.pem"}.Validate(), "profile sets client_key without client_cert")
+	assert.EqualError(t, Profile{AdfsHost: "h", User: "u", TLSMinVersion: "1.4"}.Validate(),
+		`profile tls_min_version: unknown tls version "1.4", expected 1.0, 1.1, 1.2 or 1.3`)
+}
+
+func TestKerberosAuthenticator(t *testing.T) {
+
+	authenticator := Profile{AdfsHost: "h", Auth: "Kerberos", Keytab: "user.keytab", KerberosPrincipal: "user@TEST.COM"}.Authenticator("")
+	require.NotNil(t, authenticator.Kerberos)
+	assert.Equal(t, "user.keytab", authenticator.Kerberos.Keytab)
+	assert.Equal(t, "user@TEST.COM", authenticator.Kerberos.Principal)
+
+	assert.False(t, authenticator.NTLM)
+	assert.Nil(t, Profile{AdfsHost: "h", User: "u"}.Authenticator("p").Kerberos)
+
+	authenticator = Profile{AdfsHost: "h", User: "u", Auth: "kerberos,ntlm"}.Authenticator("p")
+	assert.NotNil(t, authenticator.Kerberos)
+	assert.True(t, authenticator.NTLM)
+	assert.True(t, Profile{Auth: "ntlm"}.NeedsPassword())
+	assert.False(t, Profile{Auth: "kerberos"}.NeedsPassword())
+}
+
+func TestLoginFormAuthenticator(t *testing.T) {
+
+	os.Setenv("AWS_ADFS_PASSWORD_FIELD", "Secret")
+	defer os.Unsetenv("AWS_ADFS_PASSWORD_FIELD")
+
+	config, err := Load(writeConfig(t, "config.yaml", "profiles:\n  theme:\n    adfs_host: h\n    login_form: '#loginForm'\n    user_field: Login\n"))
+	require.NoError(t, err)
+	profile, err := config.Profile("theme")
+	require.NoError(t, err)
+
+	loginForm := profile.Authenticator("").LoginForm
+	assert.Equal(t, "#loginForm", loginForm.Selector)
+	assert.Equal(t, "Login", loginForm.UserField)
+	assert.Equal(t, "Secret", loginForm.PasswordField)
+}
+
+func TestTransportAuthenticator(t *testing.T) {
+
+	os.Setenv("AWS_ADFS_INSECURE_SKIP_VERIFY", "true")
+	defer os.Unsetenv("AWS_ADFS_INSECURE_SKIP_VERIFY")
+
+	config, err := Load(writeConfig(t, "config.yaml", `profiles:
+  base:
+    adfs_host: h
+    ca_bundle: corp-ca.pem
+    proxy: http://proxy.corp:8080
+  lab:
+    inherits: base
+    no_proxy: .corp
+    tls_min_version: "1.3"
+`))
+	require.NoError(t, err)
+	profile, err := config.Profile("lab")
+	require.NoError(t, err)
+
+	assert.Equal(t, client.TransportOptions{CABundle: "corp-ca.pem", Proxy: "http://proxy.corp:8080", NoProxy: ".corp",
+		TLSMinVersion: tls.VersionTLS13, InsecureSkipVerify: true}, profile.Authenticator("").Transport)
+}
+
+func TestInheritedInsecureSkipVerify(t *testing.T) {
+
+	config, err := Load(writeConfig(t, "config.yaml", `profiles:
+  lab:
+    adfs_host: h
+    insecure_skip_verify: true
+  lab-eu:
+    inherits: lab
+  prod:
+    inherits: lab
+    insecure_skip_verify: false
+`))
+	require.NoError(t, err)
+
+	for name, insecure := range map[string]bool{"lab": true, "lab-eu": true, "prod": false} {
+		profile, err := config.Profile(name)
+		require.NoError(t, err)
+		assert.Equal(t, insecure, profile.Authenticator("").Transport.InsecureSkipVerify, name)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+
+	os.Setenv("AWS_ADFS_RETRY_ATTEMPTS", "5")
+	defer os.Unsetenv("AWS_ADFS_RETRY_ATTEMPTS")
+
+	config, err := Load(writeConfig(t, "config.toml", "[profiles.lab]\nadfs_host = 'h'\nretry_backoff = '1s'\n"))
+	require.NoError(t, err)
+	profile, err := config.Profile("lab")
+	require.NoError(t, err)
+
+	expected := retry.DefaultPolicy()
+	expected.MaxAttempts, expected.Backoff = 5, time.Second
+	assert.Equal(t, expected, profile.Authenticator("").Retry)
+	assert.Equal(t, expected, profile.LoginOptions().Retry)
+	assert.Equal(t, retry.Policy{}, Profile{RetryAttempts: 1}.LoginOptions().Retry)
+}
+
+var yamlConfig = `
+default: lab
+profiles:
+  base:
+    adfs_host: https://sso.test.com
+    user: SEA\dicktracy
+    mfa_device: phone1
+    mfa_factor: Duo Push
+    sts_region: eu-west-1
+    duration: 1h
+    timeout: 1m
+  lab:
+    inherits: base
+    account: eps-lab
+    role: ADFS-Developers
+    duration: 2h
+    output_profile: lab
+  prod:
+    inherits: lab
+    role_arn: arn:aws:iam::456:role/Admin
+    relying_party: govcloud
+    duration: 1h
+`
+
+var tomlConfig = `
+default = "lab"
+
+[profiles.base]
+adfs_host = "https://sso.test.com"
+user = 'SEA\dicktracy'
+mfa_device = "phone1"
+mfa_factor = "Duo Push"
+sts_region = "eu-west-1"
+duration = "1h"
+timeout = "1m"
+
+[profiles.lab]
+inherits = "base"
+account = "eps-lab"
+role = "ADFS-Developers"
+duration = "2h"
+output_profile = "lab"
+
+[profiles.prod]
+inherits = "lab"
+role_arn = "arn:aws:iam::456:role/Admin"
+relying_party = "govcloud"
+duration = "1h"
+`
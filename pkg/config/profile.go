@@ -0,0 +1,172 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/client"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
+	"strings"
+	"time"
+)
+
+// Values of profile auth field, kerberos and ntlm can be combined as 'kerberos,ntlm' to fall back to NTLM
+// when ADFS does not offer kerberos. Certificate logs in with client_cert on ADFS certificate authentication endpoint
+const (
+	AuthForm        = "form"
+	AuthKerberos    = "kerberos"
+	AuthNTLM        = "ntlm"
+	AuthCertificate = "certificate"
+)
+
+// Checks that profile contains everything needed to log in
+func (p Profile) Validate() error {
+
+	var missing []string
+	if p.AdfsHost == "" {
+		missing = append(missing, "adfs_host")
+	}
+	if p.User == "" && p.NeedsPassword() {
+		missing = append(missing, "user")
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("profile is missing %s", strings.Join(missing, ", "))
+	}
+
+	if p.RoleArn != "" && (p.Account != "" || p.Role != "") {
+		return errors.New("profile sets both role_arn and account/role, only one can be used")
+	}
+	if p.MFAFactor != "" && p.MFADevice == "" {
+		return errors.New("profile sets mfa_factor without mfa_device")
+	}
+	methods := p.authMethods()
+	for method := range methods {
+		if method != AuthForm && method != AuthKerberos && method != AuthNTLM && method != AuthCertificate {
+			return fmt.Errorf("profile auth %q is not %s, %s, %s or %s", p.Auth, AuthForm, AuthKerberos, AuthNTLM, AuthCertificate)
+		}
+	}
+	for _, method := range []string{AuthForm, AuthCertificate} {
+		if methods[method] && len(methods) > 1 {
+			return fmt.Errorf("profile auth %q combines %s with other authentication", p.Auth, method)
+		}
+	}
+	if methods[AuthCertificate] && p.ClientCert == "" {
+		return errors.New("profile auth certificate needs client_cert")
+	}
+	if methods[AuthKerberos] && p.Keytab != "" && p.KerberosPrincipal == "" {
+		return errors.New("profile sets keytab without kerberos_principal")
+	}
+	if p.ClientKey != "" && p.ClientCert == "" {
+		return errors.New("profile sets client_key without client_cert")
+	}
+	if _, err := client.ParseTLSVersion(p.TLSMinVersion); err != nil {
+		return fmt.Errorf("profile tls_min_version: %v", err)
+	}
+	return nil
+}
+
+// Returns true if profile logs in with kerberos ticket
+func (p Profile) UsesKerberos() bool {
+	return p.authMethods()[AuthKerberos]
+}
+
+// Returns true if login needs password, i.e. login form or NTLM is used
+func (p Profile) NeedsPassword() bool {
+	methods := p.authMethods()
+	return methods[AuthForm] || methods[AuthNTLM]
+}
+
+// defaults to form
+func (p Profile) authMethods() map[string]bool {
+
+	methods := make(map[string]bool)
+	for _, method := range strings.Split(p.Auth, ",") {
+		if method = strings.ToLower(strings.TrimSpace(method)); method != "" {
+			methods[method] = true
+		}
+	}
+	if len(methods) == 0 {
+		methods[AuthForm] = true
+	}
+	return methods
+}
+
+// Returns authenticator for the profile, password is not part of the config
+func (p Profile) Authenticator(password string) client.Authenticator {
+
+	authenticator := client.Authenticator{
+		AdfsHost:  p.AdfsHost,
+		SignOn:    client.SignOn{URL: p.LoginURL, Path: p.SignOnPath, RelyingParty: p.RelyingParty},
+		LoginForm: client.LoginForm{Selector: p.LoginForm, UserField: p.UserField, PasswordField: p.PasswordField},
+		User:      p.User,
+		Password:  password,
+		Timeout:   time.Duration(p.Timeout),
+		MFADevice: p.MFADevice,
+		MFAFactor: p.MFAFactor,
+	}
+	if p.UsesKerberos() {
+		authenticator.Kerberos = &client.Kerberos{Keytab: p.Keytab, Principal: p.KerberosPrincipal}
+	}
+	authenticator.NTLM = p.authMethods()[AuthNTLM]
+	authenticator.CertificateAuth = p.authMethods()[AuthCertificate]
+	if p.Trace != "" {
+		authenticator.Trace = &client.Trace{Path: p.Trace}
+	}
+	// tls version is checked by Validate
+	tlsMinVersion, _ := client.ParseTLSVersion(p.TLSMinVersion)
+	authenticator.Transport = client.TransportOptions{
+		CABundle:           p.CABundle,
+		ClientCert:         p.ClientCert,
+		ClientKey:          p.ClientKey,
+		Proxy:              p.Proxy,
+		NoProxy:            p.NoProxy,
+		TLSMinVersion:      tlsMinVersion,
+		InsecureSkipVerify: p.InsecureSkipVerify != nil && *p.InsecureSkipVerify,
+	}
+	authenticator.Retry = p.retryPolicy()
+	return authenticator
+}
+
+func (p Profile) LoginOptions() aws.LoginOptions {
+	return aws.LoginOptions{Duration: time.Duration(p.Duration), Region: p.STSRegion, Retry: p.retryPolicy()}
+}
+
+// default policy with profile attempts and backoff, no retries if attempts are not set
+func (p Profile) retryPolicy() retry.Policy {
+
+	if p.RetryAttempts <= 1 {
+		return retry.Policy{}
+	}
+	policy := retry.DefaultPolicy()
+	policy.MaxAttempts = p.RetryAttempts
+	if p.RetryBackoff != 0 {
+		policy.Backoff = time.Duration(p.RetryBackoff)
+	}
+	return policy
+}
+
+// Selects profile role from roles returned by login, by role arn or by account (name or id) and role name.
+// Account and role can be glob patterns or regular expressions, see aws.RoleQuery
+func (p Profile) SelectRole(roles aws.Roles) (aws.Role, error) {
+
+	if p.RoleArn != "" {
+		return roles.RoleByRoleArn(p.RoleArn)
+	}
+	if p.Account == "" && p.Role == "" {
+		return aws.Role{}, errors.New("profile does not set role_arn, account or role")
+	}
+	return roles.Select(aws.RoleQuery{Account: p.Account, Role: p.Role})
+}
@@ -0,0 +1,45 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Debug logging of login steps shared by client, duo, saml and aws packages
+package debuglog
+
+import (
+	"net/url"
+)
+
+// Receives debug events of the login steps with key value pairs, *slog.Logger implements it. Events never contain
+// passwords, saml assertions, duo signatures, session ids, cookies or credentials
+type Logger interface {
+	Debug(msg string, args ...interface{})
+}
+
+// Returns the logger, or logger that discards events if it is nil
+func OrNop(logger Logger) Logger {
+	if logger == nil {
+		return nopLogger{}
+	}
+	return logger
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+
+// Returns url without query and fragment, that can hold session ids and tokens, for logging
+func URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
@@ -0,0 +1,320 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ecs serves ADFS credentials locally in ECS container credentials endpoint format, containers and sdks
+// use it by setting AWS_CONTAINER_CREDENTIALS_FULL_URI and AWS_CONTAINER_AUTHORIZATION_TOKEN
+package ecs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/mfa/duo"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CredentialsPath = "/credentials"
+	RolesPath       = "/roles"
+	RolePath        = "/role"
+)
+
+// how often credentials are checked in background, provider refreshes them ahead of expiration. Failed refresh is
+// retried after doubled interval, at most after max backoff. Variables so that tests can shorten them
+var (
+	refreshInterval   = time.Minute
+	maxRefreshBackoff = 30 * time.Minute
+)
+
+type Server struct {
+	roles         aws.Roles
+	authenticator aws.Authenticator
+	options       aws.LoginOptions
+	token         string
+
+	mu       sync.Mutex
+	provider *aws.CredentialsProvider
+	// background refresh stops after mfa is denied or times out, until credentials request logs in successfully
+	mfaRejected bool
+}
+
+// Roles are result of client login and authenticator is used to log in again when saml assertion expires,
+// every request needs to send token in Authorization header
+func NewServer(roles aws.Roles, roleArn string, authenticator aws.Authenticator, options aws.LoginOptions, token string) (*Server, error) {
+
+	if token == "" {
+		return nil, errors.New("ecs server: authorization token is required")
+	}
+
+	s := &Server{roles: roles, authenticator: authenticator, options: options, token: token}
+	if err := s.SetRole(roleArn); err != nil {
+		return nil, fmt.Errorf("ecs server: %v", err)
+	}
+	return s, nil
+}
+
+// Returns random token to be used as AWS_CONTAINER_AUTHORIZATION_TOKEN
+func NewToken() (string, error) {
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Switches role served by credentials endpoint, latest saml assertion is reused for the new role
+func (s *Server) SetRole(roleArn string) error {
+
+	role, err := s.roles.RoleByRoleArn(roleArn)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.provider != nil {
+		role.SamlAssertion = s.provider.Role().SamlAssertion
+	}
+	s.provider = aws.NewCredentialsProvider(role, s.authenticator, s.options)
+	return nil
+}
+
+func (s *Server) Role() aws.Role {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.provider.Role()
+}
+
+// Serves credentials until context is cancelled, credentials are refreshed in background so callers do not wait
+// for login. Failed refresh is retried with exponential backoff, before served credentials expire. Refresh that
+// failed on denied or timed out mfa is not retried in background, it would keep sending pushes or calls
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+
+	server := &http.Server{Handler: s.Handler()}
+	go func() {
+		failures := 0
+		for {
+			delay := refreshInterval
+			if expiration, err := s.refresh(ctx); err != nil {
+				failures++
+				delay = refreshBackoff(failures, expiration)
+			} else {
+				failures = 0
+			}
+			select {
+			case <-ctx.Done():
+				server.Close()
+				return
+			case <-time.After(delay):
+			}
+		}
+	}()
+
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// refreshes credentials unless mfa was rejected, returns expiration of served credentials, zero if there are none,
+// and refresh error. Errors are also returned to the next credentials request
+func (s *Server) refresh(ctx context.Context) (time.Time, error) {
+
+	s.mu.Lock()
+	provider, mfaRejected := s.provider, s.mfaRejected
+	s.mu.Unlock()
+	if mfaRejected {
+		return time.Time{}, nil
+	}
+
+	creds, err := provider.Credentials(ctx)
+	if err == nil {
+		// cached credentials are returned when refresh fails before they expire
+		err = provider.RefreshError()
+	}
+	if errors.Is(err, duo.ErrDenied) || errors.Is(err, duo.ErrTimeout) {
+		s.mu.Lock()
+		s.mfaRejected = true
+		s.mu.Unlock()
+		return time.Time{}, nil
+	}
+	return creds.Expiration, err
+}
+
+// returns delay before next refresh after consecutive failures, it doubles with each failure up to max backoff,
+// and is cut so that refresh is retried before served credentials expire
+func refreshBackoff(failures int, expiration time.Time) time.Duration {
+
+	delay := refreshInterval
+	for i := 1; i < failures && delay < maxRefreshBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRefreshBackoff {
+		delay = maxRefreshBackoff
+	}
+	if untilExpiration := time.Until(expiration); untilExpiration > 0 && untilExpiration < delay {
+		delay = untilExpiration
+	}
+	return delay
+}
+
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(CredentialsPath, s.handleCredentials)
+	mux.HandleFunc(RolesPath, s.handleRoles)
+	mux.HandleFunc(RolePath, s.handleRole)
+	return s.authorize(mux)
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// sdk sends token as it is, bearer prefix is accepted for other clients
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "Unauthorized", "invalid authorization token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type credentialsResponse struct {
+	RoleArn         string
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+}
+
+func (s *Server) handleCredentials(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	provider := s.provider
+	s.mu.Unlock()
+
+	creds, err := provider.Credentials(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "CredentialsUnavailable", err.Error())
+		return
+	}
+	if provider.RefreshError() == nil {
+		s.mu.Lock()
+		s.mfaRejected = false
+		s.mu.Unlock()
+	}
+
+	writeJSON(w, http.StatusOK, credentialsResponse{
+		RoleArn:         provider.Role().Arn,
+		AccessKeyId:     creds.AccessKeyId,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      creds.Expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+type roleResponse struct {
+	AccountId   string
+	AccountName string
+	RoleArn     string
+	RoleName    string
+	Active      bool
+}
+
+func (s *Server) handleRoles(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not allowed")
+		return
+	}
+
+	active := s.Role().Arn
+	roles := make([]roleResponse, 0, len(s.roles))
+	for _, role := range s.roles {
+		roles = append(roles, roleResponse{
+			AccountId:   role.Account.Id,
+			AccountName: role.Account.Name,
+			RoleArn:     role.Arn,
+			RoleName:    role.Name,
+			Active:      role.Arn == active,
+		})
+	}
+	writeJSON(w, http.StatusOK, roles)
+}
+
+type setRoleRequest struct {
+	RoleArn string
+}
+
+func (s *Server) handleRole(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var req setRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("decode request: %v", err))
+			return
+		}
+		if err := s.SetRole(req.RoleArn); err != nil {
+			writeError(w, http.StatusNotFound, "RoleNotFound", err.Error())
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not allowed")
+		return
+	}
+
+	role := s.Role()
+	writeJSON(w, http.StatusOK, roleResponse{
+		AccountId:   role.Account.Id,
+		AccountName: role.Account.Name,
+		RoleArn:     role.Arn,
+		RoleName:    role.Name,
+		Active:      true,
+	})
+}
+
+// error format expected by aws sdk endpoint credentials provider
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Code: code, Message: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
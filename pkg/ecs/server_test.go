@@ -0,0 +1,211 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws/awstest"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/mfa/duo"
+	"github.com/aws/aws-sdk-go-v2/credentials/endpointcreds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testRoles() aws.Roles {
+
+	samlAssertion := awstest.SamlAssertion(time.Now().Add(5 * time.Minute))
+	return aws.Roles{
+		{
+			Account:       aws.Account{Id: "123456789", Name: "lab"},
+			Arn:           "arn:aws:iam::123456789:role/ADFS-User",
+			Name:          "ADFS-User",
+			PrincipalArn:  "arn:aws:iam::123456789:saml-provider/ADFS",
+			SamlAssertion: samlAssertion,
+		},
+		{
+			Account:       aws.Account{Id: "987654321", Name: "prod"},
+			Arn:           "arn:aws:iam::987654321:role/ADFS-Admin",
+			Name:          "ADFS-Admin",
+			PrincipalArn:  "arn:aws:iam::987654321:saml-provider/ADFS",
+			SamlAssertion: samlAssertion,
+		},
+	}
+}
+
+func newTestServer(t *testing.T, stsClient aws.STSClient) (*Server, *httptest.Server) {
+
+	s, err := NewServer(testRoles(), "arn:aws:iam::123456789:role/ADFS-User", nil, aws.LoginOptions{STSClient: stsClient}, "test-token")
+	require.NoError(t, err)
+	server := httptest.NewServer(s.Handler())
+	return s, server
+}
+
+func TestCredentialsWithSDKProvider(t *testing.T) {
+
+	_, server := newTestServer(t, awstest.NewFakeSTSClient())
+	defer server.Close()
+
+	provider := endpointcreds.New(server.URL+CredentialsPath, func(o *endpointcreds.Options) {
+		o.AuthorizationToken = "test-token"
+	})
+	creds, err := provider.Retrieve(context.Background())
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, creds.AccessKeyID)
+	assert.NotEmpty(t, creds.SecretAccessKey)
+	assert.NotEmpty(t, creds.SessionToken)
+	assert.True(t, creds.CanExpire)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), creds.Expires, time.Minute)
+}
+
+func TestCredentialsUnauthorized(t *testing.T) {
+
+	_, server := newTestServer(t, awstest.NewFakeSTSClient())
+	defer server.Close()
+
+	for _, token := range []string{"", "wrong-token", "Bearer wrong-token"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+CredentialsPath, nil)
+		req.Header.Set("Authorization", token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+CredentialsPath, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestSwitchRole(t *testing.T) {
+
+	stsClient := awstest.NewFakeSTSClient()
+	s, server := newTestServer(t, stsClient)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+RolePath, strings.NewReader(`{"RoleArn": "arn:aws:iam::987654321:role/ADFS-Admin"}`))
+	req.Header.Set("Authorization", "test-token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "arn:aws:iam::987654321:role/ADFS-Admin", s.Role().Arn)
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+RolesPath, nil)
+	req.Header.Set("Authorization", "test-token")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var roles []roleResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&roles))
+	require.Equal(t, 2, len(roles))
+	assert.False(t, roles[0].Active)
+	assert.True(t, roles[1].Active)
+	assert.Equal(t, "prod", roles[1].AccountName)
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+CredentialsPath, nil)
+	req.Header.Set("Authorization", "test-token")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "arn:aws:iam::987654321:role/ADFS-Admin", stsClient.Inputs()[0].RoleArn)
+}
+
+func TestSwitchToUnknownRole(t *testing.T) {
+
+	s, server := newTestServer(t, awstest.NewFakeSTSClient())
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+RolePath, strings.NewReader(`{"RoleArn": "arn:aws:iam::1:role/Unknown"}`))
+	req.Header.Set("Authorization", "test-token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "arn:aws:iam::123456789:role/ADFS-User", s.Role().Arn)
+}
+
+func TestRefreshBackoff(t *testing.T) {
+
+	assert.Equal(t, time.Minute, refreshBackoff(1, time.Time{}))
+	assert.Equal(t, 2*time.Minute, refreshBackoff(2, time.Time{}))
+	assert.Equal(t, 4*time.Minute, refreshBackoff(3, time.Time{}))
+	assert.Equal(t, 30*time.Minute, refreshBackoff(6, time.Time{}))
+	assert.Equal(t, 30*time.Minute, refreshBackoff(100, time.Time{}))
+	assert.InDelta(t, 3*time.Minute, refreshBackoff(6, time.Now().Add(3*time.Minute)), float64(time.Second),
+		"refresh is retried before credentials expire")
+}
+
+type failingAuthenticator struct {
+	calls int32
+	err   error
+}
+
+func (a *failingAuthenticator) LoadAWSRoles() (aws.Roles, error) {
+	atomic.AddInt32(&a.calls, 1)
+	return nil, a.err
+}
+
+// serves role with expired saml assertion for the duration, refresh logs in with authenticator
+func serveWithAuthenticator(t *testing.T, authenticator aws.Authenticator, duration time.Duration) {
+
+	defer func(interval, backoff time.Duration) { refreshInterval, maxRefreshBackoff = interval, backoff }(refreshInterval, maxRefreshBackoff)
+	refreshInterval, maxRefreshBackoff = 10*time.Millisecond, 80*time.Millisecond
+
+	roles := testRoles()
+	roles[0].SamlAssertion = awstest.SamlAssertion(time.Now().Add(-time.Minute))
+	s, err := NewServer(roles, roles[0].Arn, authenticator, aws.LoginOptions{STSClient: awstest.NewFakeSTSClient()}, "test-token")
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	require.NoError(t, s.Serve(ctx, listener))
+}
+
+func TestServeBacksOffFailedRefresh(t *testing.T) {
+
+	authenticator := &failingAuthenticator{err: errors.New("adfs unavailable")}
+	serveWithAuthenticator(t, authenticator, 300*time.Millisecond)
+
+	// refresh every 10ms would log in 30 times, backoff 10, 20, 40, 80, 80ms ... about 6 times
+	calls := atomic.LoadInt32(&authenticator.calls)
+	assert.True(t, calls > 1, "failed refresh is retried")
+	assert.True(t, calls <= 10, "failed refresh backs off, %d logins", calls)
+}
+
+func TestServeDoesNotRetryRejectedMFA(t *testing.T) {
+
+	for _, mfaErr := range []error{duo.ErrDenied, duo.ErrTimeout} {
+		authenticator := &failingAuthenticator{err: fmt.Errorf("device phone1 factor Duo Push status: %w", mfaErr)}
+		serveWithAuthenticator(t, authenticator, 100*time.Millisecond)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&authenticator.calls), mfaErr.Error())
+	}
+}
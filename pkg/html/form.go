@@ -14,52 +14,257 @@ limitations under the License.
 package html
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 )
 
+const (
+	EncTypeURLEncoded = "application/x-www-form-urlencoded"
+	EncTypeMultipart  = "multipart/form-data"
+	EncTypeTextPlain  = "text/plain"
+)
+
 type Form struct {
 	Action *url.URL
 	Method string
-	Values url.Values
+	// encoding of POST body, one of EncType constants
+	EncType string
+	Values  url.Values
 }
 
+// Loads form as it is submitted by its default (first) submit button, like when enter is pressed in a field
 func LoadForm(pageUrl *url.URL, formSelection *goquery.Selection) (Form, error) {
+	return LoadFormSubmittedBy(pageUrl, formSelection, nil)
+}
+
+// Loads form as it is submitted by clicking the submitter button, only the clicked button is part of the values
+// and its formaction, formmethod and formenctype attributes override those of the form. Follows browser rules:
+// disabled fields and unchecked checkboxes and radio buttons are skipped, selects submit their selected options,
+// fields outside the form element that refer to it by 'form' attribute are included
+func LoadFormSubmittedBy(pageUrl *url.URL, formSelection, submitter *goquery.Selection) (Form, error) {
 
 	// initial form with default Values
-	form := Form{Method: http.MethodGet, Action: pageUrl, Values: make(url.Values)}
+	form := Form{Method: http.MethodGet, Action: pageUrl, EncType: EncTypeURLEncoded, Values: make(url.Values)}
+	formSelection = formSelection.First()
 
-	// update Action and Method if it has been returned in response
-	if a, ok := formSelection.Attr("action"); ok && a != "" {
-		u, err := form.Action.Parse(a)
-		if err != nil {
-			return form, fmt.Errorf("cannot parse form Action attribute %s: %v", a, err)
-		}
-		form.Action = u
+	fields := formFields(formSelection)
+	if submitter == nil {
+		submitter = fields.FilterFunction(func(_ int, field *goquery.Selection) bool { return isSubmitButton(field) }).First()
 	}
-	if a, ok := formSelection.Attr("method"); ok && a != "" {
-		form.Method = strings.ToUpper(a)
+
+	// update Action, Method and EncType if it has been returned in response, submitter takes precedence
+	for _, s := range []struct {
+		selection *goquery.Selection
+		prefix    string
+	}{{formSelection, ""}, {submitter, "form"}} {
+		if a, ok := s.selection.Attr(s.prefix + "action"); ok && a != "" {
+			u, err := pageUrl.Parse(a)
+			if err != nil {
+				return form, fmt.Errorf("cannot parse form Action attribute %s: %v", a, err)
+			}
+			form.Action = u
+		}
+		if a, ok := s.selection.Attr(s.prefix + "method"); ok && a != "" {
+			form.Method = strings.ToUpper(a)
+		}
+		if a, ok := s.selection.Attr(s.prefix + "enctype"); ok {
+			form.EncType = encType(a)
+		}
 	}
 
-	// load form input fields
-	formSelection.Find("input").Each(func(i int, selection *goquery.Selection) {
-		if name, ok := selection.Attr("name"); ok {
-			value, _ := selection.Attr("value")
-			form.Values.Add(name, value)
+	// load form fields
+	fields.Each(func(i int, field *goquery.Selection) {
+		name, ok := field.Attr("name")
+		if !ok || name == "" || isDisabled(field) {
+			return
+		}
+
+		switch goquery.NodeName(field) {
+		case "select":
+			options := field.Find("option").Not("[disabled]")
+			selected := options.Filter("[selected]")
+			if _, multiple := field.Attr("multiple"); !multiple {
+				if selected.Length() == 0 {
+					selected = options
+				}
+				selected = selected.First()
+			}
+			selected.Each(func(_ int, option *goquery.Selection) {
+				form.Values.Add(name, optionValue(option))
+			})
+		case "textarea":
+			form.Values.Add(name, field.Text())
+		case "button":
+			if isSubmitButton(field) && field.IsSelection(submitter) {
+				form.Values.Add(name, field.AttrOr("value", ""))
+			}
+		default:
+			switch inputType(field) {
+			case "checkbox", "radio":
+				if _, checked := field.Attr("checked"); checked {
+					form.Values.Add(name, field.AttrOr("value", "on"))
+				}
+			case "submit":
+				if field.IsSelection(submitter) {
+					form.Values.Add(name, field.AttrOr("value", ""))
+				}
+			case "image":
+				// clicked image submits coordinates of the click
+				if field.IsSelection(submitter) {
+					form.Values.Add(name+".x", "0")
+					form.Values.Add(name+".y", "0")
+				}
+			case "button", "reset":
+			default:
+				value, _ := field.Attr("value")
+				form.Values.Add(name, value)
+			}
 		}
 	})
 	return form, nil
 }
 
+// listed elements of the form in document order, including fields outside the form element that refer to the form
+// by id in 'form' attribute and excluding fields inside the form element that refer to other form
+func formFields(formSelection *goquery.Selection) *goquery.Selection {
+
+	root := formSelection
+	if parents := formSelection.Parents(); parents.Length() != 0 {
+		root = parents.Last()
+	}
+	formId, hasId := formSelection.Attr("id")
+	return root.Find("input, select, textarea, button").FilterFunction(func(_ int, field *goquery.Selection) bool {
+		if owner, ok := field.Attr("form"); ok {
+			return hasId && owner == formId
+		}
+		return field.Closest("form").IsSelection(formSelection)
+	})
+}
+
+func inputType(field *goquery.Selection) string {
+	return strings.ToLower(field.AttrOr("type", "text"))
+}
+
+func isSubmitButton(field *goquery.Selection) bool {
+
+	switch goquery.NodeName(field) {
+	case "button":
+		// missing or invalid type is submit
+		buttonType := strings.ToLower(field.AttrOr("type", "submit"))
+		return buttonType != "button" && buttonType != "reset"
+	case "input":
+		return inputType(field) == "submit" || inputType(field) == "image"
+	}
+	return false
+}
+
+// disabled field or field in disabled fieldset, except for the fieldset's first legend
+func isDisabled(field *goquery.Selection) bool {
+
+	if _, ok := field.Attr("disabled"); ok {
+		return true
+	}
+	fieldset := field.Closest("fieldset[disabled]")
+	if fieldset.Length() == 0 {
+		return false
+	}
+	legend := fieldset.ChildrenFiltered("legend").First()
+	return legend.Length() == 0 || !field.Closest("legend").IsSelection(legend)
+}
+
+func optionValue(option *goquery.Selection) string {
+
+	if value, ok := option.Attr("value"); ok {
+		return value
+	}
+	return strings.Join(strings.Fields(option.Text()), " ")
+}
+
+// invalid or missing enctype is url encoded
+func encType(attr string) string {
+
+	switch strings.ToLower(strings.TrimSpace(attr)) {
+	case EncTypeMultipart:
+		return EncTypeMultipart
+	case EncTypeTextPlain:
+		return EncTypeTextPlain
+	}
+	return EncTypeURLEncoded
+}
+
 func (f Form) Submit(c *http.Client) (*http.Response, error) {
 
-	req, err := http.NewRequest(f.Method, f.Action.String(), strings.NewReader(f.Values.Encode()))
+	req, err := f.NewRequest()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	return c.Do(req)
 }
+
+// Returns request that submits the form, forms other than POST replace query of the action with the values
+func (f Form) NewRequest() (*http.Request, error) {
+
+	if f.Method != http.MethodPost {
+		action := *f.Action
+		action.RawQuery = f.Values.Encode()
+		action.Fragment = ""
+		return http.NewRequest(http.MethodGet, action.String(), nil)
+	}
+
+	body, contentType, err := f.encode()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(f.Method, f.Action.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", contentType)
+	return req, nil
+}
+
+func (f Form) encode() (io.Reader, string, error) {
+
+	switch f.EncType {
+	case EncTypeMultipart:
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		for _, name := range f.names() {
+			for _, value := range f.Values[name] {
+				if err := w.WriteField(name, value); err != nil {
+					return nil, "", err
+				}
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, "", err
+		}
+		return &b, w.FormDataContentType(), nil
+	case EncTypeTextPlain:
+		var b strings.Builder
+		for _, name := range f.names() {
+			for _, value := range f.Values[name] {
+				b.WriteString(name + "=" + value + "\r\n")
+			}
+		}
+		return strings.NewReader(b.String()), EncTypeTextPlain, nil
+	}
+	return strings.NewReader(f.Values.Encode()), EncTypeURLEncoded, nil
+}
+
+func (f Form) names() []string {
+
+	names := make([]string, 0, len(f.Values))
+	for name := range f.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package html
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func loadTestForm(t *testing.T, html, selector string) Form {
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	require.NoError(t, err)
+	pageUrl, _ := url.Parse("https://sso.test.com/adfs/ls/")
+	form, err := LoadForm(pageUrl, doc.Find(selector))
+	require.NoError(t, err)
+	return form
+}
+
+func TestLoadForm(t *testing.T) {
+
+	form := loadTestForm(t, htmlForm, "#loginForm")
+
+	assert.Equal(t, "https://sso.test.com/adfs/ls/idpinitiatedsignon.aspx", form.Action.String())
+	assert.Equal(t, "POST", form.Method)
+	assert.Equal(t, EncTypeURLEncoded, form.EncType)
+	assert.Equal(t, url.Values{
+		"UserName":   {"user"},
+		"AuthMethod": {"FormsAuthentication"},
+		"Kmsi":       {"true"},
+		"Remember":   {"on"},
+		"Method":     {"push"},
+		"Device":     {"phone2"},
+		"Region":     {"eu"},
+		"Roles":      {"admin", "dev"},
+		"Comment":    {"line one\nline two"},
+		"Submit":     {"Sign in"},
+		"External":   {"outside"},
+		"Legend":     {"legend"},
+	}, form.Values)
+}
+
+func TestLoadFormSubmittedBy(t *testing.T) {
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlForm))
+	require.NoError(t, err)
+	pageUrl, _ := url.Parse("https://sso.test.com/adfs/ls/")
+
+	form, err := LoadFormSubmittedBy(pageUrl, doc.Find("#loginForm"), doc.Find("#cancel"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://sso.test.com/adfs/ls/cancel", form.Action.String())
+	assert.Equal(t, EncTypeMultipart, form.EncType)
+	assert.Equal(t, []string{"Cancel"}, form.Values["Cancel"])
+	assert.Empty(t, form.Values["Submit"])
+}
+
+func TestFormRequestEncoding(t *testing.T) {
+
+	form := loadTestForm(t, `<form method="post" enctype="multipart/form-data" action="/upload">
+<input name="a" value="1"><input name="b" value="2"></form>`, "form")
+	req, err := form.NewRequest()
+	require.NoError(t, err)
+
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, EncTypeMultipart, mediaType)
+	multipartForm, err := multipart.NewReader(req.Body, params["boundary"]).ReadForm(1024)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"a": {"1"}, "b": {"2"}}, multipartForm.Value)
+
+	form = loadTestForm(t, `<form method="post" enctype="TEXT/PLAIN"><input name="a" value="1"></form>`, "form")
+	req, err = form.NewRequest()
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, EncTypeTextPlain, req.Header.Get("Content-Type"))
+	assert.Equal(t, "a=1\r\n", string(body))
+
+	form = loadTestForm(t, `<form action="/search?old=1#top"><input name="q" value="a b"></form>`, "form")
+	req, err = form.NewRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "GET", req.Method)
+	assert.Equal(t, "https://sso.test.com/search?q=a+b", req.URL.String())
+}
+
+var htmlForm = `
+<html>
+    <body>
+        <form id="loginForm" method="post" action="idpinitiatedsignon.aspx">
+            <input name="UserName" type="email" value="user" />
+            <input name="Password" type="password" disabled />
+            <input name="AuthMethod" type="hidden" value="FormsAuthentication" />
+            <input name="Kmsi" type="checkbox" value="true" checked />
+            <input name="Unchecked" type="checkbox" value="true" />
+            <input name="Remember" type="CHECKBOX" checked />
+            <input name="Method" type="radio" value="call" />
+            <input name="Method" type="radio" value="push" checked />
+            <select name="Device">
+                <option value="phone1">Phone 1</option>
+                <option value="phone2" selected>Phone 2</option>
+            </select>
+            <select name="Region">
+                <option disabled>Choose</option>
+                <option>  eu  </option>
+                <option>us</option>
+            </select>
+            <select name="Roles" multiple>
+                <option value="admin" selected>Admin</option>
+                <option value="dev" selected>Developer</option>
+                <option value="ops" selected disabled>Operations</option>
+            </select>
+            <textarea name="Comment">line one
+line two</textarea>
+            <fieldset disabled>
+                <legend><input name="Legend" value="legend" /></legend>
+                <input name="Fieldset" value="disabled" />
+            </fieldset>
+            <input name="Other" value="other" form="otherForm" />
+            <input type="button" name="Button" value="Button" />
+            <input type="reset" name="Reset" value="Reset" />
+            <input type="submit" name="Submit" value="Sign in" />
+            <button id="cancel" name="Cancel" value="Cancel" formaction="cancel" formenctype="multipart/form-data">Cancel</button>
+        </form>
+        <input name="External" value="outside" form="loginForm" />
+        <form id="otherForm"></form>
+    </body>
+</html>
+`
@@ -14,38 +14,157 @@ limitations under the License.
 package html
 
 import (
+	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/brotli"
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 )
 
+// Returns body of successful response decompressed (gzip, deflate, br) and converted to utf-8 from charset of
+// Content-Type header, or of html meta charset for html pages
 func ReadResponseBody(response *http.Response) ([]byte, error) {
 
+	defer response.Body.Close()
 	if response.StatusCode/100 != 2 {
 		return nil, fmt.Errorf("status code %d", response.StatusCode)
 	}
-	defer response.Body.Close()
 
-	// cannot rely on 'response.Uncompressed', anything that is not 'gzip' will be just read
-	contentEncoding := response.Header.Get("Content-Encoding")
-	if contentEncoding != "gzip" {
-		return ioutil.ReadAll(response.Body)
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
 	}
+	// cannot rely on 'response.Uncompressed', it is set only when transport requested gzip itself
+	if body, err = Decompress(body, response.Header.Get("Content-Encoding")); err != nil {
+		return nil, err
+	}
+	return toUTF8(body, response.Header.Get("Content-Type"))
+}
 
-	reader, err := gzip.NewReader(response.Body)
+func LoadDocument(r *http.Response) (*goquery.Document, error) {
+
+	body, err := ReadResponseBody(r)
 	if err != nil {
 		return nil, err
 	}
-	return ioutil.ReadAll(reader)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// relative links and form actions are resolved against the page url
+	if r.Request != nil {
+		doc.Url = r.Request.URL
+	}
+	return doc, nil
 }
 
-func LoadDocument(r *http.Response) (*goquery.Document, error) {
+// Removes content codings of the body (gzip, deflate, br), codings are listed in the order they were applied
+func Decompress(body []byte, contentEncoding string) ([]byte, error) {
 
-	defer r.Body.Close()
-	if r.StatusCode/100 != 2 {
-		return nil, fmt.Errorf("status code %d", r.StatusCode)
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		var reader io.Reader
+		var err error
+		switch coding := strings.ToLower(strings.TrimSpace(codings[i])); coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			// deflate should be zlib stream, some servers send raw deflate
+			if reader, err = zlib.NewReader(bytes.NewReader(body)); err != nil {
+				reader, err = flate.NewReader(bytes.NewReader(body)), nil
+			}
+		case "br":
+			reader = brotli.NewReader(bytes.NewReader(body))
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %s", coding)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", codings[i], err)
+		}
+		if body, err = ioutil.ReadAll(reader); err != nil {
+			return nil, fmt.Errorf("%s: %v", codings[i], err)
+		}
+	}
+	return body, nil
+}
+
+// html pages are sniffed like browsers do (byte order mark, header charset, meta charset), other content uses
+// header charset only. Content without declared charset is left as it is when it is valid utf-8, browsers' fallback
+// to windows-1252 would garble utf-8 pages that have only ascii in the sniffed first 1024 bytes
+func toUTF8(body []byte, contentType string) ([]byte, error) {
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	var name string
+	if mediaType == "" || mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		var certain bool
+		_, name, certain = charset.DetermineEncoding(body, contentType)
+		if !certain && metaCharset(body) == "" && utf8.Valid(body) {
+			return body, nil
+		}
+	} else if cs, ok := params["charset"]; ok {
+		_, name = charset.Lookup(cs)
+	}
+
+	if name == "" || name == "utf-8" {
+		return body, nil
+	}
+	encoding, _ := charset.Lookup(name)
+	decoded, err := encoding.NewDecoder().Bytes(body)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %v", name, err)
+	}
+	return decoded, nil
+}
+
+// returns known charset of meta tag in the first 1024 bytes (where charset prescan looks for it), byte order mark
+// and header charset are reported as certain by charset.DetermineEncoding, meta charset is not
+func metaCharset(body []byte) string {
+
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	z := xhtml.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case xhtml.ErrorToken:
+			return ""
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			tag, hasAttr := z.TagName()
+			if string(tag) != "meta" {
+				continue
+			}
+			var cs, httpEquiv, content string
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = z.TagAttr()
+				switch string(key) {
+				case "charset":
+					cs = string(value)
+				case "http-equiv":
+					httpEquiv = string(value)
+				case "content":
+					content = string(value)
+				}
+			}
+			if cs == "" && strings.EqualFold(httpEquiv, "content-type") {
+				_, params, _ := mime.ParseMediaType(content)
+				cs = params["charset"]
+			}
+			if e, name := charset.Lookup(cs); e != nil {
+				return name
+			}
+		}
 	}
-	return goquery.NewDocumentFromReader(r.Body)
 }
@@ -0,0 +1,113 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package html
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newTestResponse(status int, contentType, contentEncoding string, body []byte) *http.Response {
+
+	header := make(http.Header)
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Encoding", contentEncoding)
+	return &http.Response{StatusCode: status, Header: header, Body: ioutil.NopCloser(bytes.NewReader(body))}
+}
+
+func compress(t *testing.T, body []byte, newWriter func(io.Writer) io.WriteCloser) []byte {
+
+	var b bytes.Buffer
+	w := newWriter(&b)
+	_, err := w.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+func TestReadResponseBodyContentEncoding(t *testing.T) {
+
+	body := []byte(`{"stat": "OK"}`)
+	gzipWriter := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+	zlibWriter := func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+	flateWriter := func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, flate.DefaultCompression); return fw }
+	brotliWriter := func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }
+
+	for encoding, compressed := range map[string][]byte{
+		"":             body,
+		"identity":     body,
+		"gzip":         compress(t, body, gzipWriter),
+		"deflate":      compress(t, body, zlibWriter),
+		"Deflate ":     compress(t, body, flateWriter),
+		"br":           compress(t, body, brotliWriter),
+		"gzip, br":     compress(t, compress(t, body, gzipWriter), brotliWriter),
+		"deflate,gzip": compress(t, compress(t, body, zlibWriter), gzipWriter),
+	} {
+		decoded, err := ReadResponseBody(newTestResponse(200, "application/json", encoding, compressed))
+		require.NoError(t, err, encoding)
+		assert.Equal(t, string(body), string(decoded), encoding)
+	}
+
+	_, err := ReadResponseBody(newTestResponse(200, "application/json", "compress", body))
+	assert.EqualError(t, err, "unsupported content encoding compress")
+	_, err = ReadResponseBody(newTestResponse(500, "application/json", "", body))
+	assert.EqualError(t, err, "status code 500")
+}
+
+func TestReadResponseBodyCharset(t *testing.T) {
+
+	for _, c := range []struct {
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"text/html; charset=iso-8859-1", "<p>Anmeldung f\xfcr</p>", "<p>Anmeldung für</p>"},
+		{"text/html", "<meta charset=windows-1250><p>P\xf8ihl\xe1sit</p>", "<meta charset=windows-1250><p>Přihlásit</p>"},
+		{"", "<meta http-equiv=Content-Type content='text/html; charset=windows-1250'><p>P\xf8ihl\xe1sit</p>",
+			"<meta http-equiv=Content-Type content='text/html; charset=windows-1250'><p>Přihlásit</p>"},
+		{"text/html; charset=utf-8", "<meta charset=windows-1250><p>Přihlásit</p>", "<meta charset=windows-1250><p>Přihlásit</p>"},
+		{"application/json; charset=iso-8859-1", "{\"message\": \"f\xfcr\"}", `{"message": "für"}`},
+		{"application/json", `{"message": "für"}`, `{"message": "für"}`},
+		// ascii in the first 1024 bytes would be sniffed as windows-1252
+		{"text/html", "<html><head><title>" + strings.Repeat("Sign In ", 200) + "</title></head><p>Zürich</p>",
+			"<html><head><title>" + strings.Repeat("Sign In ", 200) + "</title></head><p>Zürich</p>"},
+		{"", strings.Repeat(" ", 1100) + "<p>Zürich</p>", strings.Repeat(" ", 1100) + "<p>Zürich</p>"},
+		{"text/html", "<meta charset=iso-8859-1>" + strings.Repeat(" ", 1100) + "<p>Z\xfcrich</p>",
+			"<meta charset=iso-8859-1>" + strings.Repeat(" ", 1100) + "<p>Zürich</p>"},
+		{"text/html", strings.Repeat(" ", 1100) + "<p>Z\xfcrich</p>", strings.Repeat(" ", 1100) + "<p>Zürich</p>"},
+	} {
+		decoded, err := ReadResponseBody(newTestResponse(200, c.contentType, "", []byte(c.body)))
+		require.NoError(t, err, c.contentType)
+		assert.Equal(t, c.expected, string(decoded), c.contentType)
+	}
+}
+
+func TestLoadDocumentCharset(t *testing.T) {
+
+	body := compress(t, []byte("<html><head><meta charset=\"iso-8859-2\"></head><body><label>U\xbeivatel</label></body></html>"),
+		func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	doc, err := LoadDocument(newTestResponse(200, "text/html", "gzip", body))
+	require.NoError(t, err)
+	assert.Equal(t, "Uživatel", doc.Find("label").Text())
+}
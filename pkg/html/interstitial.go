@@ -0,0 +1,134 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package html
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maximum number of interstitial pages followed before the page with content
+const MaxInterstitialHops = 5
+
+var scriptSubmit = regexp.MustCompile(`\.submit\s*\(`)
+
+// Loads document of the response, pages that only submit themselves (javascript auto-post form or meta refresh)
+// are followed until page with content or the target page is reached. Target is checked before the page is
+// followed e.g. SAML response form that auto-posts to the service provider, nil if any content page is the target
+func FollowInterstitials(c *http.Client, r *http.Response, isTarget func(*goquery.Document) bool) (*goquery.Document, error) {
+
+	for hops := 0; ; hops++ {
+		doc, err := LoadDocument(r)
+		if err != nil {
+			return nil, err
+		}
+		if isTarget != nil && isTarget(doc) {
+			return doc, nil
+		}
+		next, err := interstitialRequest(doc)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			return doc, nil
+		}
+		if hops == MaxInterstitialHops {
+			return nil, fmt.Errorf("more than %d interstitial pages, last %s", MaxInterstitialHops, doc.Url)
+		}
+		if r, err = c.Do(next); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// returns request of the next page, or nil if the document is not interstitial page
+func interstitialRequest(doc *goquery.Document) (*http.Request, error) {
+
+	if doc.Url == nil {
+		return nil, nil
+	}
+
+	// page that user interacts with is never followed, even if it has scripts that submit forms
+	if doc.Find("input, select, textarea").FilterFunction(func(_ int, field *goquery.Selection) bool {
+		if goquery.NodeName(field) != "input" {
+			return true
+		}
+		switch inputType(field) {
+		case "hidden", "submit", "button", "image":
+			return false
+		}
+		return true
+	}).Length() != 0 {
+		return nil, nil
+	}
+
+	if refresh, ok := metaRefreshURL(doc); ok {
+		u, err := doc.Url.Parse(refresh)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse meta refresh url %s: %v", refresh, err)
+		}
+		return http.NewRequest(http.MethodGet, u.String(), nil)
+	}
+
+	if !submitsByScript(doc) {
+		return nil, nil
+	}
+	form := doc.Find("form").First()
+	if form.Length() == 0 {
+		return nil, nil
+	}
+	f, err := LoadForm(doc.Url, form)
+	if err != nil {
+		return nil, err
+	}
+	return f.NewRequest()
+}
+
+// returns url of '<meta http-equiv="refresh" content="0; url=...">', refresh without url only reloads the page
+func metaRefreshURL(doc *goquery.Document) (string, bool) {
+
+	var refreshURL string
+	doc.Find("meta[http-equiv]").EachWithBreak(func(_ int, meta *goquery.Selection) bool {
+		if !strings.EqualFold(meta.AttrOr("http-equiv", ""), "refresh") {
+			return true
+		}
+		content := meta.AttrOr("content", "")
+		i := strings.IndexAny(content, ";,")
+		if i < 0 {
+			return true
+		}
+		u := strings.TrimSpace(content[i+1:])
+		if len(u) > 4 && strings.EqualFold(u[:4], "url=") {
+			u = strings.TrimSpace(u[4:])
+		}
+		refreshURL = strings.Trim(u, `'"`)
+		return refreshURL == ""
+	})
+	return refreshURL, refreshURL != ""
+}
+
+// body onload or inline script that calls form submit()
+func submitsByScript(doc *goquery.Document) bool {
+
+	if scriptSubmit.MatchString(doc.Find("body").AttrOr("onload", "")) {
+		return true
+	}
+	scripts := doc.Find("script").FilterFunction(func(_ int, script *goquery.Selection) bool {
+		return scriptSubmit.MatchString(script.Text())
+	})
+	return scripts.Length() != 0
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package html
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newInterstitialServer() *httptest.Server {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/refresh", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><meta http-equiv="Refresh" content="0; URL='/autopost'"></head><body>Redirecting</body></html>`))
+	})
+	mux.HandleFunc("/autopost", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body onload="document.forms[0].submit()">
+<form method="POST" action="/content"><input type="hidden" name="token" value="t1" />
+<noscript><input type="submit" value="Continue" /></noscript></form></body></html>`))
+	})
+	mux.HandleFunc("/script", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><form method="POST" action="/content"><input type="hidden" name="token" value="t2" /></form>
+<script>window.setTimeout('document.forms[0].submit()', 0);</script></body></html>`))
+	})
+	mux.HandleFunc("/content", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><form><input name="token" value="` + r.FormValue("token") + `" />
+<script>document.forms[0].submit();</script></form></body></html>`))
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><meta http-equiv="refresh" content="1;url=/loop"></head></html>`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestFollowInterstitials(t *testing.T) {
+
+	server := newInterstitialServer()
+	defer server.Close()
+
+	for path, token := range map[string]string{"/refresh": "t1", "/autopost": "t1", "/script": "t2"} {
+		r, err := http.Get(server.URL + path)
+		require.NoError(t, err, path)
+		doc, err := FollowInterstitials(http.DefaultClient, r, nil)
+		require.NoError(t, err, path)
+		assert.Equal(t, server.URL+"/content", doc.Url.String(), path)
+		assert.Equal(t, token, doc.Find("input[name=token]").AttrOr("value", ""), path)
+	}
+}
+
+func TestFollowInterstitialsStopsAtTarget(t *testing.T) {
+
+	server := newInterstitialServer()
+	defer server.Close()
+
+	r, err := http.Get(server.URL + "/refresh")
+	require.NoError(t, err)
+	doc, err := FollowInterstitials(http.DefaultClient, r, func(doc *goquery.Document) bool {
+		return doc.Find("input[name=token]").Length() != 0
+	})
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/autopost", doc.Url.String())
+}
+
+func TestFollowInterstitialsHopLimit(t *testing.T) {
+
+	server := newInterstitialServer()
+	defer server.Close()
+
+	r, err := http.Get(server.URL + "/loop")
+	require.NoError(t, err)
+	_, err = FollowInterstitials(http.DefaultClient, r, nil)
+	assert.EqualError(t, err, "more than 5 interstitial pages, last "+server.URL+"/loop")
+}
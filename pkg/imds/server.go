@@ -0,0 +1,198 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package imds emulates EC2 instance metadata service (IMDSv2) credentials endpoints for tools that read
+// credentials only from instance metadata, sdks use it by setting AWS_EC2_METADATA_SERVICE_ENDPOINT
+package imds
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TokenPath       = "/latest/api/token"
+	CredentialsPath = "/latest/meta-data/iam/security-credentials/"
+
+	tokenHeader    = "X-aws-ec2-metadata-token"
+	tokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	maxTokenTTL    = 6 * time.Hour
+)
+
+type Server struct {
+	provider *aws.CredentialsProvider
+	// serve requests without session token (IMDSv1), for tools that do not support IMDSv2
+	AllowV1 bool
+
+	mu     sync.Mutex
+	tokens map[string]time.Time // token -> expiration
+}
+
+// Credentials of the role are obtained with role login, authenticator logs in again when saml assertion expires
+func NewServer(role aws.Role, authenticator aws.Authenticator, options aws.LoginOptions) *Server {
+	return &Server{
+		provider: aws.NewCredentialsProvider(role, authenticator, options),
+		tokens:   make(map[string]time.Time),
+	}
+}
+
+// Serves metadata until context is cancelled
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+
+	server := &http.Server{Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(TokenPath, s.handleToken)
+	mux.Handle(CredentialsPath, s.authorize(http.HandlerFunc(s.handleCredentials)))
+	return mux
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// same as ec2, token requests forwarded by a proxy are rejected
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	ttlSeconds, err := strconv.Atoi(r.Header.Get(tokenTTLHeader))
+	ttl := time.Duration(ttlSeconds) * time.Second
+	if err != nil || ttl <= 0 || ttl > maxTokenTTL {
+		http.Error(w, "invalid "+tokenTTLHeader, http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.newToken(ttl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set(tokenTTLHeader, strconv.Itoa(ttlSeconds))
+	fmt.Fprint(w, token)
+}
+
+func (s *Server) newToken(ttl time.Duration) (string, error) {
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t, expiration := range s.tokens {
+		if now.After(expiration) {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens[token] = now.Add(ttl)
+	return token, nil
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(tokenHeader)
+		if token == "" && s.AllowV1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.mu.Lock()
+		expiration, ok := s.tokens[token]
+		s.mu.Unlock()
+		if !ok || time.Now().After(expiration) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type credentialsResponse struct {
+	Code            string
+	LastUpdated     string
+	Type            string
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+}
+
+func (s *Server) handleCredentials(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// instance profile role name, sdks list roles first and then request credentials of the first one
+	roleName := s.provider.Role().Name
+	switch strings.TrimPrefix(r.URL.Path, CredentialsPath) {
+	case "":
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, roleName)
+		return
+	case roleName:
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	creds, err := s.provider.Credentials(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentialsResponse{
+		Code:            "Success",
+		LastUpdated:     time.Now().UTC().Format(time.RFC3339),
+		Type:            "AWS-HMAC",
+		AccessKeyId:     creds.AccessKeyId,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      creds.Expiration.UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,118 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imds
+
+import (
+	"context"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws/awstest"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	sdkimds "github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testRole = aws.Role{
+	Account:       aws.Account{Id: "123456789", Name: "lab"},
+	Arn:           "arn:aws:iam::123456789:role/ADFS-User",
+	Name:          "ADFS-User",
+	PrincipalArn:  "arn:aws:iam::123456789:saml-provider/ADFS",
+	SamlAssertion: awstest.SamlAssertion(time.Now().Add(5 * time.Minute)),
+}
+
+func TestCredentialsWithSDKProvider(t *testing.T) {
+
+	s := NewServer(testRole, nil, aws.LoginOptions{STSClient: awstest.NewFakeSTSClient()})
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	provider := ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+		o.Client = sdkimds.New(sdkimds.Options{Endpoint: server.URL})
+	})
+	creds, err := provider.Retrieve(context.Background())
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, creds.AccessKeyID)
+	assert.NotEmpty(t, creds.SecretAccessKey)
+	assert.NotEmpty(t, creds.SessionToken)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), creds.Expires, time.Minute)
+}
+
+func TestCredentialsRequireToken(t *testing.T) {
+
+	s := NewServer(testRole, nil, aws.LoginOptions{STSClient: awstest.NewFakeSTSClient()})
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + CredentialsPath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+CredentialsPath, nil)
+	req.Header.Set(tokenHeader, "not-issued")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestCredentialsV1(t *testing.T) {
+
+	s := NewServer(testRole, nil, aws.LoginOptions{STSClient: awstest.NewFakeSTSClient()})
+	s.AllowV1 = true
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + CredentialsPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ADFS-User", string(b))
+
+	resp, err = http.Get(server.URL + CredentialsPath + "Unknown")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestTokenTTL(t *testing.T) {
+
+	s := NewServer(testRole, nil, aws.LoginOptions{STSClient: awstest.NewFakeSTSClient()})
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	for ttl, status := range map[string]int{"": 400, "0": 400, "21601": 400, "60": 200, "21600": 200} {
+		req, _ := http.NewRequest(http.MethodPut, server.URL+TokenPath, nil)
+		req.Header.Set(tokenTTLHeader, ttl)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode, "ttl %q", ttl)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+TokenPath, nil)
+	req.Header.Set(tokenTTLHeader, "60")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
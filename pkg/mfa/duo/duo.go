@@ -17,6 +17,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
 	"github.com/HotelsDotCom/aws-adfs-login/pkg/html"
 	"github.com/PuerkitoBio/goquery"
 	"net/http"
@@ -32,15 +33,30 @@ type LoginRequester interface {
 // DUO entry point, client needs to be configured with cookiejar and requester submit method needs to return
 // initial DUO login screen
 func Login(c *http.Client, requester LoginRequester) (Devices, error) {
+	return LoginWithLogger(c, requester, nil)
+}
+
+// Logs in and logs debug events of the steps, also when factor loads roles, logger can be nil
+func LoginWithLogger(c *http.Client, requester LoginRequester, logger debuglog.Logger) (Devices, error) {
 
+	logger = debuglog.OrNop(logger)
 	loginResponse, err := login(c, requester)
 	if err != nil {
 		return nil, fmt.Errorf("duo: %v", err)
 	}
-	return initAuthentication(c, loginResponse)
+	logger.Debug("duo mfa detected", "duo_host", loginResponse.duoHost, "auth_method", loginResponse.authMethod)
+
+	devices, err := initAuthentication(c, loginResponse, logger)
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range devices {
+		logger.Debug("duo device parsed", "device", device.Name, "factors", len(device.Factors))
+	}
+	return devices, nil
 }
 
-func initAuthentication(c *http.Client, loginResponse loginResponse) (Devices, error) {
+func initAuthentication(c *http.Client, loginResponse loginResponse, logger debuglog.Logger) (Devices, error) {
 
 	authResponse, err := postInitAuthentication(c, loginResponse)
 	if err != nil {
@@ -51,7 +67,7 @@ func initAuthentication(c *http.Client, loginResponse loginResponse) (Devices, e
 	if err != nil {
 		return nil, fmt.Errorf("duo: read auth response: %v", err)
 	}
-	return parseInitAuthenticationResponse(c, loginResponse, authResponse.Request.URL, authResponseBody)
+	return parseInitAuthenticationResponse(c, loginResponse, authResponse.Request.URL, authResponseBody, logger)
 }
 
 func postInitAuthentication(c *http.Client, loginResponse loginResponse) (*http.Response, error) {
@@ -106,7 +122,7 @@ func postInitAuthentication(c *http.Client, loginResponse loginResponse) (*http.
 	return c.Do(req)
 }
 
-func parseInitAuthenticationResponse(c *http.Client, loginResponse loginResponse, requestUrl *url.URL, response []byte) (Devices, error) {
+func parseInitAuthenticationResponse(c *http.Client, loginResponse loginResponse, requestUrl *url.URL, response []byte, logger debuglog.Logger) (Devices, error) {
 
 	sid := requestUrl.Query().Get("sid")
 	if sid == "" {
@@ -127,7 +143,7 @@ func parseInitAuthenticationResponse(c *http.Client, loginResponse loginResponse
 	})
 
 	// populate all devices with factors
-	dFactory := newFactorFactory(c, loginResponse, requestUrl.Host, sid)
+	dFactory := newFactorFactory(c, loginResponse, requestUrl.Host, sid, logger)
 	for deviceName := range devices {
 		selector := fmt.Sprintf("fieldset[data-device-index=%q] input[name='factor']", deviceName)
 		doc.Find(selector).Each(func(i int, selection *goquery.Selection) {
@@ -23,7 +23,7 @@ import (
 func TestParseInitAuthenticationResponse(t *testing.T) {
 
 	requestUrl, _ := url.Parse("https://some_url.com?sid=123456")
-	devices, err := parseInitAuthenticationResponse(nil, loginResponse{}, requestUrl, []byte(duoAuthenticationResponse))
+	devices, err := parseInitAuthenticationResponse(nil, loginResponse{}, requestUrl, []byte(duoAuthenticationResponse), nil)
 	require.NoError(t, err)
 
 	require.Equal(t, 1, len(devices))
@@ -14,13 +14,22 @@ limitations under the License.
 package duo
 
 import (
+	"errors"
 	"fmt"
 	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
 	"github.com/HotelsDotCom/aws-adfs-login/pkg/saml"
 	"net/http"
 	"time"
 )
 
+// Returned (wrapped) by factor login when user denies the request or does not respond in time, such login should
+// not be retried without user asking for it, it would send another push or call
+var (
+	ErrDenied  = errors.New("denied")
+	ErrTimeout = errors.New("time out")
+)
+
 type Devices map[string]Device
 
 type Device struct {
@@ -33,14 +42,16 @@ type factorFactory struct {
 	loginResponse loginResponse
 	duoHost       string
 	sid           string
+	logger        debuglog.Logger
 }
 
-func newFactorFactory(client *http.Client, loginResponse loginResponse, duoHost, sid string) factorFactory {
+func newFactorFactory(client *http.Client, loginResponse loginResponse, duoHost, sid string, logger debuglog.Logger) factorFactory {
 	return factorFactory{
 		client:        client,
 		loginResponse: loginResponse,
 		duoHost:       duoHost,
 		sid:           sid,
+		logger:        logger,
 	}
 }
 
@@ -50,6 +61,7 @@ func (f factorFactory) newFactor(deviceName, name string) Factor {
 		loginResponse: f.loginResponse,
 		duoHost:       f.duoHost,
 		sid:           f.sid,
+		logger:        f.logger,
 		Device:        deviceName,
 		Name:          name,
 	}
@@ -60,6 +72,7 @@ type Factor struct {
 	duoHost       string
 	loginResponse loginResponse
 	sid           string
+	logger        debuglog.Logger
 	Device        string
 	Name          string
 }
@@ -67,26 +80,31 @@ type Factor struct {
 // passcode is required only for 'Passcode' factor
 func (f Factor) LoadAWSRoles(passcode string) (aws.Roles, error) {
 
+	// factors created outside of login e.g. in tests have no logger
+	logger := debuglog.OrNop(f.logger)
+
 	frame := NewFrame(f.client, f.duoHost, f.sid)
 	if err := frame.SubmitPrompt(f.Device, f.Name, passcode); err != nil {
 		return nil, fmt.Errorf("device %s factor %s submit frame prompt: %v", f.Device, f.Name, err)
 	}
+	logger.Debug("duo prompt sent", "device", f.Device, "factor", f.Name)
 
 	// TODO make number of retries and time sleep configurable
 	for i := 0; i < 20; i++ {
 		allow, err := frame.IsStatusAllowed()
 		if err != nil {
-			return nil, fmt.Errorf("device %s factor %s status: %v", f.Device, f.Name, err)
+			return nil, fmt.Errorf("device %s factor %s status: %w", f.Device, f.Name, err)
 		}
+		logger.Debug("duo status polled", "device", f.Device, "factor", f.Name, "attempt", i+1, "allowed", allow)
 
 		if allow {
 			samlLoginForm, err := frame.LoadSamlLogin(f.loginResponse)
 			if err != nil {
 				return nil, fmt.Errorf("device %s factor %s load saml login: %v", f.Device, f.Name, err)
 			}
-			return saml.LoadAWSRoles(f.client, samlLoginForm)
+			return saml.LoadAWSRolesWithLogger(f.client, samlLoginForm, logger)
 		}
 		time.Sleep(1 * time.Second)
 	}
-	return nil, fmt.Errorf("device %s factor %s status: time out", f.Device, f.Name)
+	return nil, fmt.Errorf("device %s factor %s status: %w", f.Device, f.Name, ErrTimeout)
 }
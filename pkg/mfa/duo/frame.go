@@ -16,7 +16,8 @@ package duo
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/html"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/retry"
 	"net/http"
 	"net/url"
 	"strings"
@@ -52,7 +53,8 @@ func (f *Frame) SubmitPrompt(device, name, passcode string) error {
 		data.Add("passcode", passcode)
 	}
 
-	fr, err := f.sendRequest("prompt", data)
+	// prompt is never retried, it would send another push or call
+	fr, err := f.sendRequest("prompt", data, false)
 	if err != nil {
 		return fmt.Errorf("submit prompt request: %v", err)
 	}
@@ -71,14 +73,19 @@ func (f *Frame) IsStatusAllowed() (bool, error) {
 	data.Add("sid", f.sid)
 	data.Add("txid", f.txid)
 
-	fr, err := f.sendRequest("status", data)
+	fr, err := f.sendRequest("status", data, true)
 	if err != nil {
 		return false, fmt.Errorf("send frame status request: %v", err)
 	}
 
-	if fr.Response["status_code"].(string) == "allow" {
+	switch fr.Response["status_code"] {
+	case "allow":
 		f.resultUrl = strings.TrimPrefix(fr.Response["result_url"].(string), "/frame/")
 		return true, nil
+	case "deny":
+		return false, ErrDenied
+	case "timeout":
+		return false, ErrTimeout
 	}
 	return false, nil
 }
@@ -93,7 +100,7 @@ func (f *Frame) LoadSamlLogin(loginResponse loginResponse) (SamlLoginForm, error
 	data.Add("sid", f.sid)
 	data.Add("txid", f.txid)
 
-	fr, err := f.sendRequest(f.resultUrl, data)
+	fr, err := f.sendRequest(f.resultUrl, data, false)
 	if err != nil {
 		return SamlLoginForm{}, fmt.Errorf("send frame status request: %v", err)
 	}
@@ -104,7 +111,8 @@ func (f *Frame) LoadSamlLogin(loginResponse loginResponse) (SamlLoginForm, error
 
 // --- helper methods ---
 
-func (f *Frame) sendRequest(action string, data url.Values) (frameResponse, error) {
+// retryable requests are retried by client transport with retry policy, see retry.Request
+func (f *Frame) sendRequest(action string, data url.Values, retryable bool) (frameResponse, error) {
 
 	requestUrl, err := url.Parse(fmt.Sprintf("https://%s", f.duoHost))
 	if err != nil {
@@ -118,19 +126,17 @@ func (f *Frame) sendRequest(action string, data url.Values) (frameResponse, erro
 	}
 
 	req.Header = getDefaultHeaders()
+	if retryable {
+		req = retry.Request(req)
+	}
 	response, err := f.client.Do(req)
 	if err != nil {
 		return frameResponse{}, fmt.Errorf("response: %v", err)
 	}
 
-	if response.StatusCode/100 != 2 {
-		return frameResponse{}, fmt.Errorf("response status code %d", response.StatusCode)
-	}
-
-	defer response.Body.Close()
-	b, err := ioutil.ReadAll(response.Body)
+	b, err := html.ReadResponseBody(response)
 	if err != nil {
-		return frameResponse{}, fmt.Errorf("response body: %v", err)
+		return frameResponse{}, fmt.Errorf("response: %v", err)
 	}
 
 	fr, err := loadFrameResponse(b)
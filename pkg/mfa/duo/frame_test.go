@@ -0,0 +1,39 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package duo
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsStatusAllowed(t *testing.T) {
+
+	for statusCode, expected := range map[string]error{"pushed": nil, "deny": ErrDenied, "timeout": ErrTimeout} {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"stat": "OK", "response": {"status_code": %q}}`, statusCode)
+		}))
+
+		frame := NewFrame(server.Client(), strings.TrimPrefix(server.URL, "https://"), "sid")
+		frame.txid = "txid"
+		allow, err := frame.IsStatusAllowed()
+		assert.False(t, allow, statusCode)
+		assert.Equal(t, expected, err, statusCode)
+		server.Close()
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Retry policy of idempotent login requests and sts calls, shared by client, duo and aws packages
+package retry
+
+import (
+	"context"
+	"errors"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Status codes retried by default: too many requests, and server errors that are usually transient
+var DefaultStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// STS error codes retried by default
+var DefaultErrorCodes = []string{
+	"Throttling",
+	"ThrottlingException",
+	"RequestLimitExceeded",
+	"ServiceUnavailable",
+	"InternalFailure",
+	"IDPCommunicationError",
+}
+
+// Retries idempotent login steps (GET of login page, duo status polls, sts calls) on transient failures: retryable
+// status codes, sts error codes, and network errors (dial, tls handshake and response header timeouts, refused and
+// reset connections). Password post and duo prompt are never retried. Zero value does not retry. Requests whose
+// context is done, e.g. when http.Client Timeout fires, are not retried, the timeout covers all attempts
+type Policy struct {
+	// attempts including the first one, 0 or 1 does not retry
+	MaxAttempts int
+	// delay before the first retry, doubled for every next retry up to MaxBackoff (if set). Retry-After header of
+	// the response is used instead when it is longer
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// random part of the delay from 0 to 1, 0.5 waits between 50% and 100% of the backoff
+	Jitter float64
+	// defaults to DefaultStatusCodes and DefaultErrorCodes
+	StatusCodes []int
+	ErrorCodes  []string
+}
+
+// Returns policy with 3 attempts, 500ms backoff up to 5s, and 50% jitter
+func DefaultPolicy() Policy {
+	return Policy{MaxAttempts: 3, Backoff: 500 * time.Millisecond, MaxBackoff: 5 * time.Second, Jitter: 0.5}
+}
+
+type retryableKey struct{}
+
+// Returns request marked as idempotent, that is retried by transport returned by Policy.Transport. Body of the
+// request must be replayable (GetBody is set by http.NewRequest for in-memory bodies)
+func Request(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), retryableKey{}, true))
+}
+
+func isRetryable(r *http.Request) bool {
+	retryable, _ := r.Context().Value(retryableKey{}).(bool)
+	return retryable && (r.Body == nil || r.Body == http.NoBody || r.GetBody != nil)
+}
+
+// Returns transport that retries requests marked by Request, other requests are sent once
+func (p Policy) Transport(base http.RoundTripper, logger debuglog.Logger) http.RoundTripper {
+
+	if p.MaxAttempts <= 1 {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &retryTransport{base: base, policy: p, logger: debuglog.OrNop(logger)}
+}
+
+type retryTransport struct {
+	base   http.RoundTripper
+	policy Policy
+	logger debuglog.Logger
+}
+
+func (t *retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+
+	if !isRetryable(r) {
+		return t.base.RoundTrip(r)
+	}
+	for attempt := 1; ; attempt++ {
+		request := r
+		if attempt > 1 && r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request = r.Clone(r.Context())
+			request.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(request)
+		if attempt >= t.policy.MaxAttempts || r.Context().Err() != nil || !t.policy.retryableResponse(resp, err) {
+			return resp, err
+		}
+
+		delay := t.policy.delay(attempt, resp)
+		if resp != nil {
+			t.logger.Debug("retry request", "url", debuglog.URL(r.URL), "attempt", attempt, "status", resp.StatusCode, "delay", delay)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			t.logger.Debug("retry request", "url", debuglog.URL(r.URL), "attempt", attempt, "error", err, "delay", delay)
+		}
+		if err := sleep(r.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Calls fn until it succeeds, returns error that is not retryable or error of the last attempt. Step names the
+// call in debug events
+func (p Policy) Do(ctx context.Context, logger debuglog.Logger, step string, fn func() error) error {
+
+	logger = debuglog.OrNop(logger)
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil || !p.retryableError(err) {
+			return err
+		}
+		delay := p.delay(attempt, nil)
+		logger.Debug("retry "+step, "attempt", attempt, "error", err, "delay", delay)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func (p Policy) retryableResponse(resp *http.Response, err error) bool {
+
+	if err != nil {
+		return p.retryableError(err)
+	}
+	codes := p.StatusCodes
+	if codes == nil {
+		codes = DefaultStatusCodes
+	}
+	for _, code := range codes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// network errors and sts errors with retryable codes, cancelled and expired contexts and certificate errors are not
+// retried
+func (p Policy) retryableError(err error) bool {
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) {
+		codes := p.ErrorCodes
+		if codes == nil {
+			codes = DefaultErrorCodes
+		}
+		for _, code := range codes {
+			if apiErr.ErrorCode() == code {
+				return true
+			}
+		}
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// exponential backoff with jitter, longer Retry-After of the response takes precedence
+func (p Policy) delay(attempt int, resp *http.Response) time.Duration {
+
+	delay := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff == 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff != 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && time.Duration(seconds)*time.Second > delay {
+			delay = time.Duration(seconds) * time.Second
+			if p.MaxBackoff != 0 && delay > p.MaxBackoff {
+				delay = p.MaxBackoff
+			}
+		}
+	}
+	return delay
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,202 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// responds with statuses in order, then with 200, and records request bodies
+func newStatusServer(statuses ...int) (*httptest.Server, *[]string) {
+
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) <= len(statuses) {
+			w.WriteHeader(statuses[len(bodies)-1])
+		}
+	}))
+	return server, &bodies
+}
+
+func TestRetryTransport(t *testing.T) {
+
+	server, bodies := newStatusServer(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer server.Close()
+	c := &http.Client{Transport: Policy{MaxAttempts: 3, Backoff: time.Millisecond}.Transport(nil, nil)}
+
+	r, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("sid=1&txid=2"))
+	require.NoError(t, err)
+	resp, err := c.Do(Request(r))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"sid=1&txid=2", "sid=1&txid=2", "sid=1&txid=2"}, *bodies, "body is replayed")
+}
+
+func TestRetryTransportDoesNotRetry(t *testing.T) {
+
+	server, bodies := newStatusServer(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	defer server.Close()
+	c := &http.Client{Transport: Policy{MaxAttempts: 2, Backoff: time.Millisecond}.Transport(nil, nil)}
+
+	// password post is not marked as retryable
+	resp, err := c.PostForm(server.URL, url.Values{"Password": {"secret"}})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Len(t, *bodies, 1)
+
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp, err = c.Do(Request(r))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "response of the last attempt is returned")
+	assert.Len(t, *bodies, 3)
+
+	r, err = http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = (&http.Client{Transport: Policy{MaxAttempts: 2}.Transport(nil, nil)}).Do(Request(r.WithContext(ctx)))
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+type testAPIError string
+
+func (e testAPIError) Error() string     { return "api error " + string(e) }
+func (e testAPIError) ErrorCode() string { return string(e) }
+
+type testTimeoutError struct{}
+
+func (testTimeoutError) Error() string   { return "dial tcp: i/o timeout" }
+func (testTimeoutError) Timeout() bool   { return true }
+func (testTimeoutError) Temporary() bool { return true }
+
+func TestRetryTransportClientTimeout(t *testing.T) {
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	// client timeout covers all attempts, expired request is returned at once instead of being retried
+	c := &http.Client{Timeout: 50 * time.Millisecond, Transport: Policy{MaxAttempts: 3, Backoff: time.Millisecond}.Transport(nil, nil)}
+	r, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = c.Do(Request(r))
+	require.Error(t, err)
+	assert.True(t, err.(net.Error).Timeout(), err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// timeout of single attempt (dial, response header) is retried
+	var attempts int
+	base := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if attempts++; attempts == 1 {
+			return nil, &url.Error{Op: "Get", URL: r.URL.String(), Err: testTimeoutError{}}
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})
+	c = &http.Client{Timeout: time.Second, Transport: Policy{MaxAttempts: 3, Backoff: time.Millisecond}.Transport(base, nil)}
+	r, err = http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp, err := c.Do(Request(r))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, attempts)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestDoStopsWhenContextIsDone(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var calls int
+	err := Policy{MaxAttempts: 5, Backoff: time.Millisecond}.Do(ctx, nil, "test", func() error {
+		calls++
+		<-ctx.Done()
+		return &url.Error{Op: "Post", URL: "u", Err: testTimeoutError{}}
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryableError(t *testing.T) {
+
+	policy := DefaultPolicy()
+	for err, retryable := range map[error]bool{
+		testAPIError("Throttling"):                                         true,
+		fmt.Errorf("operation error: %w", testAPIError("Throttling")):      true,
+		testAPIError("AccessDenied"):                                       false,
+		&url.Error{Op: "Get", URL: "u", Err: syscall.ECONNRESET}:           true,
+		&url.Error{Op: "Get", URL: "u", Err: syscall.ECONNREFUSED}:         true,
+		&url.Error{Op: "Get", URL: "u", Err: testTimeoutError{}}:           true,
+		&url.Error{Op: "Get", URL: "u", Err: context.DeadlineExceeded}:     false,
+		&url.Error{Op: "Get", URL: "u", Err: context.Canceled}:             false,
+		&url.Error{Op: "Get", URL: "u", Err: x509.UnknownAuthorityError{}}: false,
+	} {
+		assert.Equal(t, retryable, policy.retryableError(err), err.Error())
+	}
+
+	policy.ErrorCodes = []string{"AccessDenied"}
+	assert.True(t, policy.retryableError(testAPIError("AccessDenied")))
+	assert.False(t, policy.retryableError(testAPIError("Throttling")))
+}
+
+func TestRetryDelay(t *testing.T) {
+
+	policy := Policy{MaxAttempts: 5, Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	assert.Equal(t, 100*time.Millisecond, policy.delay(1, nil))
+	assert.Equal(t, 200*time.Millisecond, policy.delay(2, nil))
+	assert.Equal(t, 300*time.Millisecond, policy.delay(3, nil))
+	assert.Equal(t, 300*time.Millisecond, policy.delay(10, nil))
+
+	resp := &http.Response{Header: http.Header{"Retry-After": {"2"}}}
+	assert.Equal(t, 300*time.Millisecond, policy.delay(1, resp), "retry after is limited by max backoff")
+	policy.MaxBackoff = 0
+	assert.Equal(t, 2*time.Second, policy.delay(1, resp))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		delay := policy.delay(2, nil)
+		assert.True(t, delay > 100*time.Millisecond && delay <= 200*time.Millisecond, delay)
+	}
+}
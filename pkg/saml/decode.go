@@ -0,0 +1,385 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package saml
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const roleAttribute = "https://aws.amazon.com/SAML/Attributes/Role"
+
+const (
+	DecodeFormatText = "text"
+	DecodeFormatJSON = "json"
+	DecodeFormatXML  = "xml"
+)
+
+// Decoded saml response, for inspecting the assertion e.g. when roles are missing. Signatures are listed, not verified
+type Assertion struct {
+	Destination  string `json:"destination"`
+	Issuer       string `json:"issuer"`
+	IssueInstant string `json:"issue_instant"`
+	Status       string `json:"status"`
+	// NameID of the subject and its format
+	Subject       string `json:"subject"`
+	SubjectFormat string `json:"subject_format"`
+	// subject confirmation, sts does not accept the assertion after NotOnOrAfter
+	Recipient           string `json:"recipient"`
+	SubjectNotOnOrAfter string `json:"subject_not_on_or_after"`
+	// conditions and audience restriction
+	NotBefore    string   `json:"not_before"`
+	NotOnOrAfter string   `json:"not_on_or_after"`
+	Audiences    []string `json:"audiences"`
+	// authentication statement
+	AuthnInstant        string      `json:"authn_instant"`
+	AuthnContext        string      `json:"authn_context"`
+	SessionNotOnOrAfter string      `json:"session_not_on_or_after"`
+	Attributes          []Attribute `json:"attributes"`
+	Signatures          []Signature `json:"signatures"`
+	// pairs of aws role attribute values
+	Roles []RolePair `json:"roles"`
+	// assertion is encrypted, only response fields are decoded
+	Encrypted bool `json:"encrypted"`
+	// indented xml of the response
+	XML string `json:"-"`
+}
+
+type Attribute struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// Signature of the response or assertion element, with signing certificate of KeyInfo
+type Signature struct {
+	// Response or Assertion
+	Element         string `json:"element"`
+	Algorithm       string `json:"algorithm"`
+	DigestAlgorithm string `json:"digest_algorithm"`
+	Reference       string `json:"reference"`
+	// empty if KeyInfo does not contain certificate that can be parsed
+	CertificateSubject  string     `json:"certificate_subject,omitempty"`
+	CertificateIssuer   string     `json:"certificate_issuer,omitempty"`
+	CertificateNotAfter *time.Time `json:"certificate_not_after,omitempty"`
+}
+
+// Role and saml provider arns of the aws role attribute value, Error is set if the value is malformed
+type RolePair struct {
+	RoleArn      string `json:"role_arn"`
+	PrincipalArn string `json:"principal_arn"`
+	Value        string `json:"value"`
+	Error        string `json:"error,omitempty"`
+}
+
+type responseXML struct {
+	Destination  string `xml:"Destination,attr"`
+	IssueInstant string `xml:"IssueInstant,attr"`
+	Issuer       string `xml:"Issuer"`
+	StatusCode   struct {
+		Value string `xml:"Value,attr"`
+	} `xml:"Status>StatusCode"`
+	Signature *signatureXML `xml:"Signature"`
+	Encrypted *struct{}     `xml:"EncryptedAssertion"`
+	Assertion *struct {
+		Issuer    string        `xml:"Issuer"`
+		Signature *signatureXML `xml:"Signature"`
+		NameID    struct {
+			Format string `xml:"Format,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"Subject>NameID"`
+		SubjectConfirmationData struct {
+			Recipient    string `xml:"Recipient,attr"`
+			NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+		} `xml:"Subject>SubjectConfirmation>SubjectConfirmationData"`
+		Conditions struct {
+			NotBefore    string   `xml:"NotBefore,attr"`
+			NotOnOrAfter string   `xml:"NotOnOrAfter,attr"`
+			Audiences    []string `xml:"AudienceRestriction>Audience"`
+		} `xml:"Conditions"`
+		AuthnStatement struct {
+			AuthnInstant        string `xml:"AuthnInstant,attr"`
+			SessionNotOnOrAfter string `xml:"SessionNotOnOrAfter,attr"`
+			AuthnContext        string `xml:"AuthnContext>AuthnContextClassRef"`
+		} `xml:"AuthnStatement"`
+		Attributes []struct {
+			Name   string   `xml:"Name,attr"`
+			Values []string `xml:"AttributeValue"`
+		} `xml:"AttributeStatement>Attribute"`
+	} `xml:"Assertion"`
+}
+
+type signatureXML struct {
+	SignatureMethod struct {
+		Algorithm string `xml:"Algorithm,attr"`
+	} `xml:"SignedInfo>SignatureMethod"`
+	Reference struct {
+		URI          string `xml:"URI,attr"`
+		DigestMethod struct {
+			Algorithm string `xml:"Algorithm,attr"`
+		} `xml:"DigestMethod"`
+	} `xml:"SignedInfo>Reference"`
+	Certificate string `xml:"KeyInfo>X509Data>X509Certificate"`
+}
+
+// Decodes saml response without network calls, from base64 (as in aws.Role SamlAssertion), url encoded base64
+// (SAMLResponse form value) or xml
+func Decode(samlResponse string) (Assertion, error) {
+
+	decoded, err := decodeResponse(samlResponse)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	var response responseXML
+	if err := xml.Unmarshal(decoded, &response); err != nil {
+		return Assertion{}, fmt.Errorf("parse saml response: %v", err)
+	}
+	indented, err := indentXML(decoded)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("parse saml response: %v", err)
+	}
+
+	assertion := Assertion{
+		Destination:  response.Destination,
+		Issuer:       strings.TrimSpace(response.Issuer),
+		IssueInstant: response.IssueInstant,
+		Status:       response.StatusCode.Value,
+		Encrypted:    response.Encrypted != nil,
+		XML:          indented,
+	}
+	if response.Signature != nil {
+		assertion.Signatures = append(assertion.Signatures, response.Signature.signature("Response"))
+	}
+
+	a := response.Assertion
+	if a == nil {
+		return assertion, nil
+	}
+	if issuer := strings.TrimSpace(a.Issuer); issuer != "" {
+		assertion.Issuer = issuer
+	}
+	if a.Signature != nil {
+		assertion.Signatures = append(assertion.Signatures, a.Signature.signature("Assertion"))
+	}
+	assertion.Subject, assertion.SubjectFormat = strings.TrimSpace(a.NameID.Value), a.NameID.Format
+	assertion.Recipient, assertion.SubjectNotOnOrAfter = a.SubjectConfirmationData.Recipient, a.SubjectConfirmationData.NotOnOrAfter
+	assertion.NotBefore, assertion.NotOnOrAfter = a.Conditions.NotBefore, a.Conditions.NotOnOrAfter
+	for _, audience := range a.Conditions.Audiences {
+		assertion.Audiences = append(assertion.Audiences, strings.TrimSpace(audience))
+	}
+	assertion.AuthnInstant, assertion.SessionNotOnOrAfter = a.AuthnStatement.AuthnInstant, a.AuthnStatement.SessionNotOnOrAfter
+	assertion.AuthnContext = strings.TrimSpace(a.AuthnStatement.AuthnContext)
+
+	for _, attr := range a.Attributes {
+		attribute := Attribute{Name: attr.Name}
+		for _, v := range attr.Values {
+			attribute.Values = append(attribute.Values, strings.TrimSpace(v))
+		}
+		assertion.Attributes = append(assertion.Attributes, attribute)
+		if attr.Name != roleAttribute {
+			continue
+		}
+		for _, v := range attribute.Values {
+			pair := RolePair{Value: v}
+			if pair.RoleArn, pair.PrincipalArn, err = loadArnFields(v); err != nil {
+				pair.Error = err.Error()
+			}
+			assertion.Roles = append(assertion.Roles, pair)
+		}
+	}
+	return assertion, nil
+}
+
+// Writes assertion in one of DecodeFormat constants, text is a summary followed by the xml
+func (a Assertion) Render(w io.Writer, format string) error {
+
+	switch strings.ToLower(format) {
+	case DecodeFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(a)
+	case DecodeFormatXML:
+		_, err := fmt.Fprintln(w, a.XML)
+		return err
+	case DecodeFormatText, "":
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, field := range [][2]string{
+			{"Destination", a.Destination},
+			{"Issuer", a.Issuer},
+			{"Issue instant", a.IssueInstant},
+			{"Status", a.Status},
+			{"Subject", a.Subject},
+			{"Subject format", a.SubjectFormat},
+			{"Recipient", a.Recipient},
+			{"Subject not on or after", a.SubjectNotOnOrAfter},
+			{"Not before", a.NotBefore},
+			{"Not on or after", a.NotOnOrAfter},
+			{"Audience", strings.Join(a.Audiences, ", ")},
+			{"Authn instant", a.AuthnInstant},
+			{"Authn context", a.AuthnContext},
+			{"Session not on or after", a.SessionNotOnOrAfter},
+		} {
+			if field[1] != "" {
+				fmt.Fprintf(writer, "%s:\t%s\n", field[0], field[1])
+			}
+		}
+		if a.Encrypted {
+			fmt.Fprintln(writer, "Encrypted:\tassertion is encrypted, only response is decoded")
+		}
+
+		fmt.Fprintln(writer, "\nATTRIBUTE\tVALUE")
+		for _, attribute := range a.Attributes {
+			// role pairs are listed below
+			if attribute.Name == roleAttribute {
+				continue
+			}
+			fmt.Fprintf(writer, "%s\t%s\n", attribute.Name, strings.Join(attribute.Values, ", "))
+		}
+		fmt.Fprintln(writer, "\nROLE ARN\tPRINCIPAL ARN\tERROR")
+		for _, role := range a.Roles {
+			if role.Error != "" {
+				fmt.Fprintf(writer, "%s\t\t%s\n", role.Value, role.Error)
+				continue
+			}
+			fmt.Fprintf(writer, "%s\t%s\t\n", role.RoleArn, role.PrincipalArn)
+		}
+		fmt.Fprintln(writer, "\nSIGNATURE\tALGORITHM\tREFERENCE\tCERTIFICATE\tNOT AFTER")
+		for _, signature := range a.Signatures {
+			notAfter := ""
+			if signature.CertificateNotAfter != nil {
+				notAfter = signature.CertificateNotAfter.Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", signature.Element, signature.Algorithm, signature.Reference,
+				signature.CertificateSubject, notAfter)
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "\nsignatures are not verified\n\n%s\n", a.XML)
+		return err
+	default:
+		return fmt.Errorf("unknown decode format %q", format)
+	}
+}
+
+func decodeResponse(samlResponse string) ([]byte, error) {
+
+	s := strings.TrimSpace(samlResponse)
+	if strings.HasPrefix(s, "<") {
+		return []byte(s), nil
+	}
+	if strings.HasPrefix(s, "SAMLResponse=") {
+		s = strings.TrimPrefix(s, "SAMLResponse=")
+	}
+	// base64 does not contain '%', and '+' of url encoded value is kept by path unescaping
+	if strings.Contains(s, "%") {
+		unescaped, err := url.PathUnescape(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode saml response: %v", err)
+		}
+		s = unescaped
+	}
+	s = strings.Join(strings.Fields(s), "")
+	if s == "" {
+		return nil, errors.New("saml response is empty")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode saml response: %v", err)
+	}
+	return decoded, nil
+}
+
+func (s signatureXML) signature(element string) Signature {
+
+	signature := Signature{
+		Element:         element,
+		Algorithm:       s.SignatureMethod.Algorithm,
+		DigestAlgorithm: s.Reference.DigestMethod.Algorithm,
+		Reference:       s.Reference.URI,
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s.Certificate), ""))
+	if err != nil {
+		return signature
+	}
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		signature.CertificateSubject = cert.Subject.String()
+		signature.CertificateIssuer = cert.Issuer.String()
+		signature.CertificateNotAfter = &cert.NotAfter
+	}
+	return signature
+}
+
+// re-indents xml with namespace prefixes kept as they are, whitespace between elements is dropped
+func indentXML(data []byte) (string, error) {
+
+	var b bytes.Buffer
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	encoder := xml.NewEncoder(&b)
+	encoder.Indent("", "  ")
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			t.Name = rawName(t.Name)
+			for i, attr := range t.Attr {
+				t.Attr[i].Name = rawName(attr.Name)
+			}
+			token = t
+		case xml.EndElement:
+			t.Name = rawName(t.Name)
+			token = t
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+		case xml.ProcInst:
+			// encoder writes the declaration only as the first token
+			if t.Target == "xml" {
+				continue
+			}
+		}
+		if err := encoder.EncodeToken(token); err != nil {
+			return "", err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// raw token keeps prefix in Space, encoder would treat it as namespace url
+func rawName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
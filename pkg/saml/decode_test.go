@@ -0,0 +1,137 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package saml
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(samlAssertionFieldDecoded))
+	for name, input := range map[string]string{
+		"base64":      encoded,
+		"wrapped":     "\n" + encoded[:76] + "\n" + encoded[76:] + "\n",
+		"url encoded": "SAMLResponse=" + url.QueryEscape(encoded),
+		"xml":         samlAssertionFieldDecoded,
+	} {
+		assertion, err := Decode(input)
+		require.NoError(t, err, name)
+
+		assert.Equal(t, "https://signin.aws.amazon.com/saml", assertion.Destination, name)
+		assert.Equal(t, "http://sso.test.biz/adfs/services/trust", assertion.Issuer, name)
+		assert.Equal(t, "urn:oasis:names:tc:SAML:2.0:status:Success", assertion.Status, name)
+		assert.Equal(t, `SEA\dicktracy`, assertion.Subject, name)
+		assert.Equal(t, "2018-08-06T09:39:49.660Z", assertion.SubjectNotOnOrAfter, name)
+		assert.Equal(t, "2018-08-06T09:34:49.613Z", assertion.NotBefore, name)
+		assert.Equal(t, "2018-08-06T10:34:49.613Z", assertion.NotOnOrAfter, name)
+		assert.Equal(t, []string{"urn:amazon:webservices"}, assertion.Audiences, name)
+		assert.Equal(t, "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport", assertion.AuthnContext, name)
+		assert.False(t, assertion.Encrypted, name)
+
+		require.Len(t, assertion.Attributes, 5, name)
+		assert.Equal(t, Attribute{Name: "https://redshift.amazon.com/SAML/Attributes/DbGroups", Values: []string{"Domain Users", "all dead", "read only"}},
+			assertion.Attributes[3], name)
+
+		require.Len(t, assertion.Roles, 3, name)
+		assert.Equal(t, "arn:aws:iam::123456789:role/ADFS-User", assertion.Roles[0].RoleArn, name)
+		assert.Equal(t, "arn:aws:iam::123456789:saml-provider/ADFS", assertion.Roles[0].PrincipalArn, name)
+
+		// fixture certificate is not valid, signature is listed without it
+		require.Len(t, assertion.Signatures, 1, name)
+		assert.Equal(t, Signature{
+			Element:         "Assertion",
+			Algorithm:       "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+			DigestAlgorithm: "http://www.w3.org/2001/04/xmlenc#sha256",
+			Reference:       "#_XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX",
+		}, assertion.Signatures[0], name)
+
+		assert.Contains(t, assertion.XML, "\n  <samlp:Status>\n    <samlp:StatusCode Value=\"urn:oasis:names:tc:SAML:2.0:status:Success\"></samlp:StatusCode>", name)
+		assert.Contains(t, assertion.XML, `<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#">`, name)
+		assert.Contains(t, assertion.XML, `<Assertion xmlns="urn:oasis:names:tc:SAML:2.0:assertion"`, name)
+	}
+}
+
+func TestDecodeRoleErrorsAndCertificate(t *testing.T) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "ADFS Signing - sso.test.biz"},
+		NotBefore: notAfter.AddDate(-1, 0, 0), NotAfter: notAfter}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	response := strings.Replace(samlAssertionFieldDecoded, "XXXXXXXXXXXXXXXXXXXXXXXXXXXXX</ds:X509Certificate>",
+		base64.StdEncoding.EncodeToString(der)+"</ds:X509Certificate>", 1)
+	response = strings.Replace(response, "arn:aws:iam::98765431:saml-provider/ADFS,arn:aws:iam::98765431:role/ADFS-SuperUser",
+		"arn:aws:iam::98765431:role/ADFS-SuperUser", 1)
+
+	assertion, err := Decode(response)
+	require.NoError(t, err)
+	require.Len(t, assertion.Signatures, 1)
+	assert.Equal(t, "CN=ADFS Signing - sso.test.biz", assertion.Signatures[0].CertificateSubject)
+	assert.Equal(t, "CN=ADFS Signing - sso.test.biz", assertion.Signatures[0].CertificateIssuer)
+	assert.Equal(t, &notAfter, assertion.Signatures[0].CertificateNotAfter)
+
+	require.Len(t, assertion.Roles, 3)
+	assert.Equal(t, "arn:aws:iam::98765431:role/ADFS-SuperUser", assertion.Roles[2].Value)
+	assert.NotEmpty(t, assertion.Roles[2].Error, "malformed role pair is listed with error")
+}
+
+func TestDecodeInvalid(t *testing.T) {
+
+	_, err := Decode("  ")
+	assert.EqualError(t, err, "saml response is empty")
+
+	_, err = Decode("not base64!")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot decode saml response")
+
+	_, err = Decode(base64.StdEncoding.EncodeToString([]byte("<samlp:Response><Assertion>")))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parse saml response")
+}
+
+func TestAssertionRender(t *testing.T) {
+
+	assertion, err := Decode(samlAssertionFieldDecoded)
+	require.NoError(t, err)
+
+	var b strings.Builder
+	require.NoError(t, assertion.Render(&b, DecodeFormatText))
+	assert.Contains(t, b.String(), "Audience:                 urn:amazon:webservices\n")
+	assert.Contains(t, b.String(), "arn:aws:iam::123456789:role/ADFS-Admin     arn:aws:iam::123456789:saml-provider/ADFS")
+	assert.NotContains(t, b.String(), "SAML/Attributes/Role ", "role attribute is listed as role pairs")
+	assert.True(t, strings.HasSuffix(b.String(), assertion.XML+"\n"))
+
+	b.Reset()
+	require.NoError(t, assertion.Render(&b, DecodeFormatJSON))
+	assert.Contains(t, b.String(), `"role_arn": "arn:aws:iam::98765431:role/ADFS-SuperUser"`)
+	assert.NotContains(t, b.String(), "<samlp:Response")
+
+	assert.EqualError(t, assertion.Render(&b, "yaml"), `unknown decode format "yaml"`)
+}
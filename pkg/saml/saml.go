@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/HotelsDotCom/aws-adfs-login/pkg/aws"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
 	"github.com/HotelsDotCom/aws-adfs-login/pkg/html"
 	"github.com/PuerkitoBio/goquery"
 	"net/http"
@@ -33,14 +34,22 @@ type AssertionRequester interface {
 }
 
 func LoadAWSRoles(c *http.Client, requester AssertionRequester) (aws.Roles, error) {
+	return LoadAWSRolesWithLogger(c, requester, nil)
+}
+
+// Loads roles and logs debug events of the steps, logger can be nil
+func LoadAWSRolesWithLogger(c *http.Client, requester AssertionRequester, logger debuglog.Logger) (aws.Roles, error) {
+
+	logger = debuglog.OrNop(logger)
 
 	// submit login form and load saml response
-	samlAssertionForm, err := loadSamlAssertionForm(c, requester)
+	samlAssertionForm, err := loadSamlAssertionForm(c, requester, logger)
 	if err != nil {
 		return nil, err
 	}
 
 	// submit saml assertion form to load accounts
+	logger.Debug("saml assertion submitted", "url", debuglog.URL(samlAssertionForm.Action))
 	resp, err := samlAssertionForm.Submit(c)
 	if err != nil {
 		return nil, err
@@ -53,37 +62,56 @@ func LoadAWSRoles(c *http.Client, requester AssertionRequester) (aws.Roles, erro
 	if err != nil {
 		return nil, err
 	}
+	logger.Debug("aws accounts loaded", "accounts", len(accounts))
 
 	samlAssertion := samlAssertionForm.Values.Get("SAMLResponse")
-	return loadSamlRoles(samlAssertion, accounts)
+	roles, err := loadSamlRoles(samlAssertion, accounts)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("saml roles loaded", "roles", len(roles))
+	return roles, nil
 }
 
-func loadSamlAssertionForm(c *http.Client, requester AssertionRequester) (html.Form, error) {
+func loadSamlAssertionForm(c *http.Client, requester AssertionRequester, logger debuglog.Logger) (html.Form, error) {
 
 	loginResponse, err := requester.Submit(c)
 	if err != nil {
 		return html.Form{}, err
 	}
+	logger.Debug("login submitted", "url", debuglog.URL(loginResponse.Request.URL), "status", loginResponse.StatusCode)
 
-	// load response
-	doc, err := html.LoadDocument(loginResponse)
+	// load response, proxies and duo can return pages that auto-submit before the saml assertion
+	doc, err := html.FollowInterstitials(c, loginResponse, hasSamlResponse)
 	if err != nil {
 		return html.Form{}, err
 	}
 
 	// load saml assertion form from response
-	samlAssertionForm, err := html.LoadForm(loginResponse.Request.URL, doc.Find("form"))
+	form := doc.Find("input[name=SAMLResponse]").Closest("form")
+	if form.Length() == 0 {
+		form = doc.Find("form")
+	}
+	samlAssertionForm, err := html.LoadForm(doc.Url, form)
 	if err != nil {
 		return html.Form{}, err
 	}
 
 	// simple validation
-	if v := samlAssertionForm.Values.Get("SAMLResponse"); v == "" {
+	v := samlAssertionForm.Values.Get("SAMLResponse")
+	if v == "" {
+		logger.Debug("saml assertion not found", "url", debuglog.URL(doc.Url), "title", strings.TrimSpace(doc.Find("title").Text()))
 		return samlAssertionForm, errors.New("response did not contain valid SAML assertion")
 	}
+	logger.Debug("saml assertion received", "url", debuglog.URL(doc.Url), "size", len(v))
 	return samlAssertionForm, nil
 }
 
+// saml assertion form auto-posts to the service provider, it is not followed
+func hasSamlResponse(doc *goquery.Document) bool {
+	return doc.Find("input[name=SAMLResponse]").Length() != 0
+}
+
 func loadSamlRoles(samlAssertion string, accounts map[string]string) (aws.Roles, error) {
 
 	samlResponseDecoded, err := base64.StdEncoding.DecodeString(samlAssertion)
@@ -15,9 +15,12 @@ package saml
 
 import (
 	"encoding/base64"
+	"github.com/HotelsDotCom/aws-adfs-login/pkg/debuglog"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 )
@@ -49,6 +52,37 @@ func TestLoadSamlRoles(t *testing.T) {
 	assert.Equal(t, "98765431", accRoles[0].Account.Id)
 }
 
+type getRequester string
+
+func (r getRequester) Submit(c *http.Client) (*http.Response, error) {
+	return c.Get(string(r))
+}
+
+func TestLoadSamlAssertionFormAfterInterstitial(t *testing.T) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><meta http-equiv="refresh" content="0;url=/proxy"></head></html>`))
+	})
+	mux.HandleFunc("/proxy", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body onload="document.forms[0].submit()"><form method="post" action="/adfs/ls/">
+<input type="hidden" name="context" value="c1" /></form></body></html>`))
+	})
+	mux.HandleFunc("/adfs/ls/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "c1", r.FormValue("context"))
+		w.Write([]byte(`<html><body><form method="POST" name="hiddenform" action="https://signin.aws.amazon.com:443/saml">
+<input type="hidden" name="SAMLResponse" value="assertion" /></form>
+<script language="javascript">window.setTimeout('document.forms[0].submit()', 0);</script></body></html>`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	form, err := loadSamlAssertionForm(http.DefaultClient, getRequester(server.URL+"/login"), debuglog.OrNop(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "assertion", form.Values.Get("SAMLResponse"))
+	assert.Equal(t, "https://signin.aws.amazon.com:443/saml", form.Action.String())
+}
+
 var samlAssertionFieldDecoded = `<?xml version="1.0" encoding="UTF-8"?>
 <samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="XXXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX" Version="2.0" IssueInstant="2018-08-06T09:34:49.660Z" Destination="https://signin.aws.amazon.com/saml" Consent="urn:oasis:names:tc:SAML:2.0:consent:unspecified">
    <Issuer xmlns="urn:oasis:names:tc:SAML:2.0:assertion">http://sso.test.biz/adfs/services/trust</Issuer>